@@ -2,10 +2,50 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
 	"github.com/soyunomas/nxsh/pkg/shell"
 )
 
 func main() {
-	s := shell.New()
-	s.Run()
+	command := flag.String("c", "", "ejecuta el programa indicado y termina")
+	norc := flag.Bool("norc", false, "no carga ~/.nxshrc ni $XDG_CONFIG_HOME/nxsh/config.nxsh")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "uso: nxsh [--norc] [-c programa] [script]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	// `nxsh -c ''` ejecuta un programa vacío: lo que cuenta es que se usó -c.
+	commandSet := false
+	flag.Visit(func(f *flag.Flag) {
+		commandSet = commandSet || f.Name == "c"
+	})
+
+	var s *shell.Shell
+	switch {
+	case commandSet:
+		s = shell.NewScript("-c", *command)
+	case flag.NArg() > 0:
+		source, err := os.ReadFile(flag.Arg(0))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "nxsh: %v\n", err)
+			os.Exit(1)
+		}
+		s = shell.NewScript(flag.Arg(0), string(source))
+	case !shell.IsTerminal(os.Stdin):
+		// Sin terminal (cron, CI, `echo ... | nxsh`): el programa llega por stdin.
+		source, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "nxsh: %v\n", err)
+			os.Exit(1)
+		}
+		s = shell.NewScript("<stdin>", string(source))
+	default:
+		s = shell.New(!*norc)
+	}
+
+	os.Exit(s.Run())
 }
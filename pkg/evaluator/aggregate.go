@@ -0,0 +1,325 @@
+package evaluator
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/soyunomas/nxsh/pkg/parser"
+)
+
+// aggregators son las agregaciones disponibles como comandos (`users | avg
+// .age`) y en `aggregate`. Reciben los valores de cada elemento; salvo count,
+// ignoran los null.
+var aggregators = map[string]func(values []interface{}) (interface{}, error){
+	"count":  countValues,
+	"sum":    sumValues,
+	"avg":    avgValues,
+	"min":    func(values []interface{}) (interface{}, error) { return extremeValue(values, -1), nil },
+	"max":    func(values []interface{}) (interface{}, error) { return extremeValue(values, 1), nil },
+	"median": medianValues,
+}
+
+// Aggregation es una agregación de `aggregate`, sobre los elementos o sobre el
+// campo path de cada uno: `count`, `avg(.age)`. key es el nombre del campo con
+// el resultado: `count`, `avg_age`.
+type Aggregation struct {
+	function string
+	path     []pathSegment
+	key      string
+	source   string
+}
+
+func (a *Aggregation) Type() ObjectType { return AGGREGATION_OBJ }
+func (a *Aggregation) Inspect() string  { return a.source }
+
+// evalCallExpression evalúa una llamada `nombre(args)`. Las únicas funciones
+// que se pueden llamar así son las agregaciones con campo de `aggregate`:
+// `avg(.age)`.
+func evalCallExpression(ctx context.Context, node *parser.CallExpression, env *Environment) Object {
+	name := node.Function.Value
+	if _, ok := aggregators[name]; !ok {
+		return newError("%s: no es una agregación (uso: %s(<.campo>) en aggregate)", name, name)
+	}
+	if len(node.Arguments) != 1 {
+		return newError("uso: %s(<.campo>)", name)
+	}
+	pathObj := Eval(ctx, node.Arguments[0], env)
+	if isError(pathObj) {
+		return pathObj
+	}
+	pathArg, ok := pathObj.(*String)
+	if !ok {
+		return newError("%s: la ruta debe ser una cadena, se obtuvo %s", name, pathObj.Type())
+	}
+	path, err := parsePath(pathArg.Value)
+	if err != nil {
+		return newError("%s: %v", name, err)
+	}
+	field := lastKey(path)
+	if field == "" {
+		field = strings.TrimPrefix(pathArg.Value, ".")
+	}
+	return &Aggregation{function: name, path: path, key: name + "_" + field, source: node.String()}
+}
+
+// aggregateBuiltin construye el comando de una agregación: `count`, `sum`,
+// `avg`, `min`, `max` o `median`, con un campo opcional (`sum .stars`).
+func aggregateBuiltin(name string) BuiltinFunction {
+	return func(ctx context.Context, input Object, args ...Object) Object {
+		if len(args) > 1 {
+			return newError("uso: %s [.campo]", name)
+		}
+		// `count` sobre un registro cuenta sus campos (por ejemplo, los grupos de group-by).
+		if record, ok := jsonRecord(input); ok && name == "count" && len(args) == 0 {
+			return &Integer{Value: int64(len(record))}
+		}
+		items, _, errObj := listInput(name, input)
+		if errObj != nil {
+			return errObj
+		}
+		var path []pathSegment
+		if len(args) == 1 {
+			pathArg, ok := args[0].(*String)
+			if !ok {
+				return newError("%s: el argumento de ruta debe ser una cadena, se obtuvo %s", name, args[0].Type())
+			}
+			var err error
+			if path, err = parsePath(pathArg.Value); err != nil {
+				return newError("%s: %v", name, err)
+			}
+		}
+		if err := interrupted(ctx); err != nil {
+			return err
+		}
+		result, err := aggregators[name](collectValues(items, path))
+		if err != nil {
+			return newError("%s: %v", name, err)
+		}
+		return nativeToNshObject(result)
+	}
+}
+
+// builtinGroupBy implementa `group-by <.campo>`: un registro con una lista de
+// elementos por cada valor del campo. Los elementos sin el campo van al grupo "null".
+func builtinGroupBy(ctx context.Context, input Object, args ...Object) Object {
+	if input == nil {
+		return newError("group-by: requiere una entrada de un pipeline")
+	}
+	if len(args) != 1 {
+		return newError("uso: group-by <.campo>")
+	}
+	pathArg, ok := args[0].(*String)
+	if !ok {
+		return newError("group-by: el argumento de ruta debe ser una cadena, se obtuvo %s", args[0].Type())
+	}
+	path, err := parsePath(pathArg.Value)
+	if err != nil {
+		return newError("group-by: %v", err)
+	}
+	items, errObj := inputArray("group-by", input)
+	if errObj != nil {
+		return errObj
+	}
+	groups := make(map[string]interface{})
+	for _, item := range items {
+		if err := interrupted(ctx); err != nil {
+			return err
+		}
+		value, _ := accessField(item, path)
+		key := nativeToNshObject(value).Inspect()
+		if value == nil {
+			key = "null"
+		}
+		group, _ := groups[key].([]interface{})
+		groups[key] = append(group, item)
+	}
+	return &Json{Value: groups}
+}
+
+// builtinAggregate implementa `aggregate <agregación> ...`: calcula varias
+// agregaciones sobre un array, o sobre cada grupo del registro de group-by.
+func builtinAggregate(ctx context.Context, input Object, args ...Object) Object {
+	if input == nil {
+		return newError("aggregate: requiere una entrada de un pipeline")
+	}
+	if len(args) == 0 {
+		return newError("uso: aggregate <agregación> ... (ej. aggregate count avg(.age))")
+	}
+	var specs []*Aggregation
+	for _, arg := range args {
+		switch arg := arg.(type) {
+		case *Aggregation:
+			specs = append(specs, arg)
+		case *String:
+			if _, ok := aggregators[arg.Value]; !ok {
+				return newError("aggregate: agregación desconocida '%s'", arg.Value)
+			}
+			specs = append(specs, &Aggregation{function: arg.Value, key: arg.Value, source: arg.Value})
+		default:
+			return newError("aggregate: se esperaba una agregación, se obtuvo %s", arg.Type())
+		}
+	}
+	if groups, ok := jsonRecord(input); ok {
+		results := make(map[string]interface{}, len(groups))
+		for key, group := range groups {
+			if err := interrupted(ctx); err != nil {
+				return err
+			}
+			items, ok := group.([]interface{})
+			if !ok {
+				return newError("aggregate: el grupo '%s' no es un array (usa group-by antes)", key)
+			}
+			row, errObj := aggregateRow(items, specs)
+			if errObj != nil {
+				return errObj
+			}
+			results[key] = row
+		}
+		return &Json{Value: results}
+	}
+	items, errObj := inputArray("aggregate", input)
+	if errObj != nil {
+		return errObj
+	}
+	row, errObj := aggregateRow(items, specs)
+	if errObj != nil {
+		return errObj
+	}
+	return &Json{Value: row}
+}
+
+// aggregateRow calcula las agregaciones specs sobre items.
+func aggregateRow(items []interface{}, specs []*Aggregation) (map[string]interface{}, *Error) {
+	row := make(map[string]interface{}, len(specs))
+	for _, spec := range specs {
+		result, err := aggregators[spec.function](collectValues(items, spec.path))
+		if err != nil {
+			return nil, newError("aggregate: %s: %v", spec.source, err)
+		}
+		row[spec.key] = result
+	}
+	return row, nil
+}
+
+// jsonRecord devuelve los campos de una entrada que es un objeto JSON.
+func jsonRecord(input Object) (map[string]interface{}, bool) {
+	if jsonInput, ok := input.(*Json); ok {
+		record, ok := jsonInput.Value.(map[string]interface{})
+		return record, ok
+	}
+	return nil, false
+}
+
+// collectValues extrae el campo path de cada elemento, saltando los que no lo
+// tienen. Si la ruta produce varios valores por elemento, se reúnen todos.
+func collectValues(items []interface{}, path []pathSegment) []interface{} {
+	if len(path) == 0 {
+		return items
+	}
+	fansOut := pathFansOut(path)
+	values := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		value, found := accessField(item, path)
+		if !found {
+			continue
+		}
+		if list, isList := value.([]interface{}); isList && fansOut {
+			values = append(values, list...)
+		} else {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func countValues(values []interface{}) (interface{}, error) {
+	return float64(len(values)), nil
+}
+
+// numbers devuelve los valores numéricos, sin los null. Los textos que son un
+// número cuentan como tal, como las líneas de `seq 1 5 | sum`. Cualquier otro
+// valor es un error.
+func numbers(values []interface{}) ([]float64, error) {
+	nums := make([]float64, 0, len(values))
+	for _, v := range values {
+		switch n := numericValue(v).(type) {
+		case nil:
+		case float64:
+			nums = append(nums, n)
+		default:
+			return nil, fmt.Errorf("'%s' no es un número", nativeToNshObject(v).Inspect())
+		}
+	}
+	return nums, nil
+}
+
+// numericValue convierte en número un texto que lo representa (con espacios
+// alrededor, como en la salida de `wc -l`). El resto de valores no cambian.
+func numericValue(v interface{}) interface{} {
+	if text, ok := v.(string); ok {
+		if n, err := strconv.ParseFloat(strings.TrimSpace(text), 64); err == nil {
+			return n
+		}
+	}
+	return v
+}
+
+func sumValues(values []interface{}) (interface{}, error) {
+	nums, err := numbers(values)
+	if err != nil {
+		return nil, err
+	}
+	total := 0.0
+	for _, n := range nums {
+		total += n
+	}
+	return total, nil
+}
+
+// avgValues devuelve la media de los números, o null si no hay ninguno.
+func avgValues(values []interface{}) (interface{}, error) {
+	nums, err := numbers(values)
+	if err != nil || len(nums) == 0 {
+		return nil, err
+	}
+	total := 0.0
+	for _, n := range nums {
+		total += n
+	}
+	return total / float64(len(nums)), nil
+}
+
+// medianValues devuelve la mediana de los números (la media de los dos
+// centrales si son pares), o null si no hay ninguno.
+func medianValues(values []interface{}) (interface{}, error) {
+	nums, err := numbers(values)
+	if err != nil || len(nums) == 0 {
+		return nil, err
+	}
+	sort.Float64s(nums)
+	mid := len(nums) / 2
+	if len(nums)%2 == 0 {
+		return (nums[mid-1] + nums[mid]) / 2, nil
+	}
+	return nums[mid], nil
+}
+
+// extremeValue devuelve el menor (sign -1) o el mayor (sign 1) de los valores,
+// con el mismo orden que sort-by, o null si no hay ninguno. Los textos que son
+// un número se comparan como números (ver numericValue).
+func extremeValue(values []interface{}, sign int) interface{} {
+	var best interface{}
+	for _, v := range values {
+		v = numericValue(v)
+		if v == nil {
+			continue
+		}
+		if best == nil || compareValues(v, best, false)*sign > 0 {
+			best = v
+		}
+	}
+	return best
+}
@@ -0,0 +1,52 @@
+package evaluator
+
+import (
+	"strings"
+	"testing"
+)
+
+const aggregateUsers = `let users = (echo '[{"role": "a", "age": 20}, {"role": "b", "age": 30}, {"role": "a", "age": null}, {"role": "b"}]')`
+
+func TestAggregations(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`[1, 2, 3, 4] | sum`, `10`},
+		{`[1, 2, 3, 4] | avg`, `2.5`},
+		{`[3, 1, 2] | median`, `2`},
+		{`[3, 1, 2] | min`, `1`},
+		{`[] | avg`, `null`},
+		{`seq 1 5 | sum`, `15`},
+		{`seq 8 11 | max`, `11`},
+		{`seq 1 4 | median`, `2.5`},
+		{`seq 1 3 | count`, `3`},
+		{aggregateUsers + `; $users | avg .age`, `25`},
+		{aggregateUsers + `; $users | count .age`, `3`},
+		{aggregateUsers + `; $users | group-by .role | count`, `2`},
+		{aggregateUsers + `; $users | aggregate count max(.age)`, `{"count":4,"max_age":30}`},
+		{aggregateUsers + `; $users | group-by .role | aggregate sum(.age)`, `{"a":{"sum_age":20},"b":{"sum_age":30}}`},
+	}
+	for _, tt := range tests {
+		testEvalOutput(t, tt.input, tt.want)
+	}
+}
+
+func TestAggregationErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`printf 'a\n1\n' | sum`, "sum: 'a' no es un número"},
+		{`[1] | aggregate foo`, "aggregate: agregación desconocida 'foo'"},
+		{`[1] | sum .a .b`, "uso: sum [.campo]"},
+		{`echo foo(.a)`, "foo: no es una agregación"},
+	}
+	for _, tt := range tests {
+		result := testEval(t, tt.input)
+		err, ok := result.(*Error)
+		if !ok || !strings.Contains(err.Message, tt.want) {
+			t.Errorf("%q: se obtuvo %s, se esperaba un error con %q", tt.input, result.Inspect(), tt.want)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package evaluator
+
+import (
+	"context"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// eachInput comprueba los argumentos de los comandos que llaman a un bloque
+// por elemento y devuelve el bloque y los elementos: los de un array JSON o
+// las líneas de un texto.
+func eachInput(name string, input Object, args []Object) (*Function, []Object, *Error) {
+	if input == nil {
+		return nil, nil, newError("%s: requiere una entrada de un pipeline", name)
+	}
+	if len(args) != 1 {
+		return nil, nil, newError("uso: %s { |x| ... }", name)
+	}
+	fn, ok := args[0].(*Function)
+	if !ok {
+		return nil, nil, newError("%s: se esperaba un bloque, se obtuvo %s", name, args[0].Type())
+	}
+	if _, isArray := jsonArray(input); !isArray && input.Type() != STRING_OBJ && input != NULL {
+		return nil, nil, newError("%s: la entrada debe ser un array JSON o texto, se obtuvo %s", name, input.Type())
+	}
+	items, errObj := iterableItems(input)
+	return fn, items, errObj
+}
+
+// jsonArray devuelve los elementos de un objeto que es un array JSON.
+func jsonArray(obj Object) ([]interface{}, bool) {
+	if jsonObj, ok := obj.(*Json); ok {
+		items, ok := jsonObj.Value.([]interface{})
+		return items, ok
+	}
+	return nil, false
+}
+
+// eachResult convierte el resultado del bloque para un elemento en un valor de
+// la lista de salida. A la salida de un comando externo se le quita el salto
+// de línea final.
+func eachResult(obj Object) interface{} {
+	if str, ok := obj.(*String); ok {
+		return strings.TrimSuffix(str.Value, "\n")
+	}
+	return objectToNative(obj)
+}
+
+// builtinEach implementa `each { |x, i| ... }` (alias `map`): llama al bloque
+// con cada elemento de un array o cada línea de un texto y devuelve la lista
+// de resultados, uno por elemento y en el mismo orden (null si el bloque no
+// produce nada). El elemento está también en `$it`, y el segundo parámetro
+// recibe su posición: `repos | each { curl -s $it.url }`.
+func builtinEach(ctx context.Context, input Object, args ...Object) Object {
+	fn, items, errObj := eachInput("each", input, args)
+	if errObj != nil {
+		return errObj
+	}
+	results := make([]interface{}, 0, len(items))
+	for i, item := range items {
+		if err := interrupted(ctx); err != nil {
+			return err
+		}
+		result := callClosure(ctx, fn, item, &Integer{Value: int64(i)})
+		if isError(result) {
+			return result
+		}
+		results = append(results, eachResult(result))
+	}
+	return &Json{Value: results}
+}
+
+// parallelKey marca el contexto de los bloques que evalúa par-each (ver
+// inBackground).
+type parallelKey struct{}
+
+// parseJobsFlag lee la opción `-j N` (o `--jobs N`) de par-each y devuelve el
+// número de workers, runtime.NumCPU() si no se indica, y el resto de argumentos.
+func parseJobsFlag(args []Object) (int, []Object, *Error) {
+	workers := runtime.NumCPU()
+	if len(args) == 0 {
+		return workers, args, nil
+	}
+	flag, ok := args[0].(*String)
+	if !ok || !strings.HasPrefix(flag.Value, "-") {
+		return workers, args, nil
+	}
+	if flag.Value != "-j" && flag.Value != "--jobs" {
+		return 0, nil, newError("par-each: opción desconocida '%s'", flag.Value)
+	}
+	if len(args) < 2 {
+		return 0, nil, newError("par-each: %s requiere un número", flag.Value)
+	}
+	n, ok := args[1].(*Integer)
+	if !ok || n.Value < 1 {
+		return 0, nil, newError("par-each: se esperaba un número positivo de workers, se obtuvo %s", args[1].Inspect())
+	}
+	return int(n.Value), args[2:], nil
+}
+
+// builtinParEach implementa `par-each [-j N] { |x, i| ... }`: como each, pero
+// evalúa el bloque en N goroutines a la vez (por defecto, una por CPU). Los
+// resultados siguen el orden de la entrada. Un elemento que falla no detiene
+// al resto: en su lugar queda `{"item": elemento, "error": registro}`, con el
+// mismo registro que recibe un catch.
+func builtinParEach(ctx context.Context, input Object, args ...Object) Object {
+	workers, args, errObj := parseJobsFlag(args)
+	if errObj != nil {
+		return errObj
+	}
+	fn, items, errObj := eachInput("par-each", input, args)
+	if errObj != nil {
+		return errObj
+	}
+	// Cada llamada al bloque tiene su propio entorno (ver callClosure), y los
+	// entornos exteriores son seguros para lecturas concurrentes.
+	workerCtx := context.WithValue(ctx, parallelKey{}, true)
+	outputs := make([]Object, len(items))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < len(items); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				outputs[i] = callClosure(workerCtx, fn, items[i], &Integer{Value: int64(i)})
+			}
+		}()
+	}
+feed:
+	for i := range items {
+		select {
+		case next <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(next)
+	wg.Wait()
+	if err := interrupted(ctx); err != nil {
+		return err
+	}
+
+	results := make([]interface{}, 0, len(items))
+	for i, output := range outputs {
+		if err, ok := output.(*Error); ok {
+			results = append(results, map[string]interface{}{
+				"item":  objectToNative(items[i]),
+				"error": errorRecord(err).Value,
+			})
+			continue
+		}
+		results = append(results, eachResult(output))
+	}
+	return &Json{Value: results}
+}
+
+// builtinReduce implementa `reduce [-f inicial] { |acc, x, i| ... }`: recorre
+// los elementos llamando al bloque con el acumulador, el elemento (también en
+// `$it`) y su posición, y el resultado de cada llamada es el acumulador de la
+// siguiente. El acumulador puede ser cualquier valor: un número, un texto o un
+// registro que se va completando. Sin -f, empieza siendo el primer elemento.
+func builtinReduce(ctx context.Context, input Object, args ...Object) Object {
+	var acc Object
+	if len(args) > 0 {
+		if flag, ok := args[0].(*String); ok && strings.HasPrefix(flag.Value, "-") {
+			if flag.Value != "-f" && flag.Value != "--fold" {
+				return newError("reduce: opción desconocida '%s'", flag.Value)
+			}
+			if len(args) < 2 {
+				return newError("reduce: %s requiere un valor inicial", flag.Value)
+			}
+			acc, args = args[1], args[2:]
+		}
+	}
+	if len(args) != 1 {
+		return newError("uso: reduce [-f inicial] { |acc, x| ... }")
+	}
+	fn, items, errObj := eachInput("reduce", input, args)
+	if errObj != nil {
+		return errObj
+	}
+	start := 0
+	if acc == nil {
+		if len(items) == 0 {
+			return newError("reduce: la entrada está vacía (usa -f <inicial>)")
+		}
+		acc, start = items[0], 1
+	}
+	for i := start; i < len(items); i++ {
+		if err := interrupted(ctx); err != nil {
+			return err
+		}
+		acc = callClosureWithIt(ctx, fn, items[i], acc, items[i], &Integer{Value: int64(i)})
+		if isError(acc) {
+			return acc
+		}
+	}
+	return acc
+}
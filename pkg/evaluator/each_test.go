@@ -0,0 +1,68 @@
+package evaluator
+
+import (
+	"strings"
+	"testing"
+)
+
+const eachUsers = `let users = [[Ann, 30], [Bob], [Cid, 25]]`
+
+func TestEach(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`[1, 2, 3] | each { $it * 2 }`, `[2,4,6]`},
+		{`[a, b] | map { |x, i| "$i=$x" }`, `["0=a","1=b"]`},
+		{eachUsers + `; $users | each { $it[1] }`, `[30,null,25]`},
+		{`[1, 2] | each { let x = $it }`, `[null,null]`},
+		{`printf 'a\nb\n' | each { echo $it }`, `["a","b"]`},
+		{`[1, 2, 3] | par-each -j 2 { $it * 10 }`, `[10,20,30]`},
+		{eachUsers + `; $users | par-each { $it[1] }`, `[30,null,25]`},
+		{`[1, 2] | reduce { |acc, x| $acc + $x }`, `3`},
+		{`[1, 2, 3] | reduce -f 10 { |acc| $acc + $it }`, `16`},
+		{`[a, b] | reduce -f "" { |acc, x, i| "$acc$i$x" }`, `0a1b`},
+		{`[] | reduce -f 5 { $it }`, `5`},
+		{`[a, b] | reduce -f {} { |acc, x, i| $acc | insert ".$x" $i }`, `{"a":0,"b":1}`},
+		{`let e = {}; $e`, `{}`},
+	}
+	for _, tt := range tests {
+		testEvalOutput(t, tt.input, tt.want)
+	}
+}
+
+func TestParEachErrors(t *testing.T) {
+	result := testEval(t, `[1, 2, 3] | par-each { if $it == 2 { throw fallo } else { $it } }`)
+	items, ok := jsonArray(result)
+	if !ok || len(items) != 3 {
+		t.Fatalf("se esperaban 3 resultados, se obtuvo %s", result.Inspect())
+	}
+	record, ok := items[1].(map[string]interface{})
+	if !ok || record["item"] != float64(2) {
+		t.Fatalf("se esperaba el registro de error del elemento 2, se obtuvo %v", items[1])
+	}
+	if errRecord, ok := record["error"].(map[string]interface{}); !ok || errRecord["message"] != "fallo" {
+		t.Errorf("registro de error inesperado: %v", record["error"])
+	}
+}
+
+func TestEachErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`each { $it }`, "each: requiere una entrada de un pipeline"},
+		{`[1] | each 1`, "each: se esperaba un bloque"},
+		{`[1] | each { |a, b, c| $a }`, "el bloque declara 3 parámetros, pero recibe 2"},
+		{`[1] | par-each -j 0 { $it }`, "par-each: se esperaba un número positivo de workers"},
+		{`[] | reduce { $it }`, "reduce: la entrada está vacía"},
+		{`[1] | reduce -x 1 { $it }`, "reduce: opción desconocida '-x'"},
+	}
+	for _, tt := range tests {
+		result := testEval(t, tt.input)
+		err, ok := result.(*Error)
+		if !ok || !strings.Contains(err.Message, tt.want) {
+			t.Errorf("%q: se obtuvo %s, se esperaba un error con %q", tt.input, result.Inspect(), tt.want)
+		}
+	}
+}
@@ -1,8 +1,22 @@
 package evaluator
 
+import (
+	"context"
+	"sync"
+)
+
 // Environment guarda los identificadores (variables) y sus valores.
+// Los entornos pueden anidarse: un bloque (como el cuerpo de un bucle) tiene su
+// propio entorno cuyo outer es el entorno que lo contiene. Es seguro usarlo
+// desde varias goroutines, como los trabajos en segundo plano.
 type Environment struct {
+	mu    sync.RWMutex
 	store map[string]Object
+	outer *Environment
+
+	// block indica que es el entorno de un bloque de `for` o `catch` (ver
+	// newBlockEnvironment).
+	block bool
 }
 
 // NewEnvironment crea un nuevo entorno de variables vacío.
@@ -11,14 +25,67 @@ func NewEnvironment() *Environment {
 	return &Environment{store: s}
 }
 
-// Get recupera un objeto del entorno por su nombre.
+// NewSessionEnvironment crea el entorno de una sesión de la shell, con `$?` y
+// `last-status` inicializados como si el último comando hubiera tenido éxito.
+func NewSessionEnvironment() *Environment {
+	env := NewEnvironment()
+	setStatus(context.Background(), env, "", NULL)
+	return env
+}
+
+// NewEnclosedEnvironment crea un entorno vacío anidado dentro de outer.
+// Las variables definidas en él no son visibles desde outer.
+func NewEnclosedEnvironment(outer *Environment) *Environment {
+	env := NewEnvironment()
+	env.outer = outer
+	return env
+}
+
+// newBlockEnvironment crea el entorno de un bloque que solo añade la variable
+// name (la de un `for` o un `catch`). El resto de variables que se definen en
+// el bloque se guardan en outer, como en los bloques de `if` y `try`, de modo
+// que un bucle puede acumular: `for i in 1..3 { let total = $total + $i }`.
+func newBlockEnvironment(outer *Environment, name string, val Object) *Environment {
+	env := NewEnclosedEnvironment(outer)
+	env.store[name] = val
+	env.block = true
+	return env
+}
+
+// Get recupera un objeto del entorno por su nombre, buscando también en los
+// entornos exteriores.
 func (e *Environment) Get(name string) (Object, bool) {
+	e.mu.RLock()
 	obj, ok := e.store[name]
+	e.mu.RUnlock()
+	if !ok && e.outer != nil {
+		obj, ok = e.outer.Get(name)
+	}
 	return obj, ok
 }
 
-// Set guarda un objeto en el entorno con un nombre específico.
+// Set guarda un objeto en el entorno con un nombre específico. En el entorno
+// de un bloque, solo la variable del bloque se guarda en él.
 func (e *Environment) Set(name string, val Object) Object {
+	if e.block {
+		e.mu.RLock()
+		_, own := e.store[name]
+		e.mu.RUnlock()
+		if !own {
+			return e.outer.Set(name, val)
+		}
+	}
+	e.mu.Lock()
 	e.store[name] = val
+	e.mu.Unlock()
 	return val
 }
+
+// SetGlobal guarda un objeto en el entorno más exterior, el de la sesión, para
+// que sea visible desde cualquier bloque o función (ej. `$?`).
+func (e *Environment) SetGlobal(name string, val Object) Object {
+	for e.outer != nil {
+		e = e.outer
+	}
+	return e.Set(name, val)
+}
@@ -2,28 +2,133 @@ package evaluator
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/soyunomas/nxsh/pkg/parser"
+	"io"
+	"io/fs"
+	"math"
 	"os"
 	"os/exec"
+	"reflect"
 	"strconv"
 	"strings"
+	"syscall"
 )
 
 var (
-	NULL = &Null{}
+	NULL  = &Null{}
+	TRUE  = &Boolean{Value: true}
+	FALSE = &Boolean{Value: false}
+
+	BREAK    = &Break{}
+	CONTINUE = &Continue{}
 )
 
 var builtins = map[string]*Builtin{
-	"cd":     {Fn: builtinCd},
-	"get":    {Fn: builtinGet},
-	"where":  {Fn: builtinWhere},
-	"select": {Fn: builtinSelect}, // <-- REGISTRAMOS SELECT
+	"cd":        {Fn: builtinCd},
+	"get":       {Fn: builtinGet},
+	"where":     {Fn: builtinWhere},
+	"throw":     {Fn: builtinThrow},
+	"error":     {Fn: builtinThrow},
+	"exit":      {Fn: builtinExit},
+	"jobs":      {Fn: builtinJobs},
+	"wait":      {Fn: builtinWait},
+	"fg":        {Fn: builtinFg},
+	"bg":        {Fn: builtinBg},
+	"kill":      {Fn: builtinKill, External: killIsExternal},
+	"sort-by":   {Fn: builtinSortBy},
+	"sort":      {Fn: builtinSort, External: sortIsExternal},
+	"first":     {Fn: builtinFirst},
+	"last":      {Fn: builtinLast},
+	"take":      {Fn: builtinTake},
+	"skip":      {Fn: builtinSkip},
+	"nth":       {Fn: builtinNth},
+	"reverse":   {Fn: builtinReverse},
+	"uniq":      {Fn: uniqBuiltin("uniq"), External: uniqIsExternal},
+	"distinct":  {Fn: uniqBuiltin("distinct")},
+	"uniq-by":   {Fn: builtinUniqBy},
+	"group-by":  {Fn: builtinGroupBy},
+	"aggregate": {Fn: builtinAggregate},
+	"count":     {Fn: aggregateBuiltin("count")},
+	"sum":       {Fn: aggregateBuiltin("sum")},
+	"avg":       {Fn: aggregateBuiltin("avg")},
+	"min":       {Fn: aggregateBuiltin("min")},
+	"max":       {Fn: aggregateBuiltin("max")},
+	"median":    {Fn: aggregateBuiltin("median")},
+	"reject":    {Fn: builtinReject},
+	"rename":    {Fn: builtinRename},
+}
+
+// Los comandos que llaman a bloques de usuario acaban usando Eval, que a su vez
+// consulta builtins, así que se registran en init para evitar un ciclo de
+// inicialización.
+func init() {
+	builtins["select"] = &Builtin{Fn: builtinSelect}
+	builtins["update"] = &Builtin{Fn: builtinUpdate}
+	builtins["insert"] = &Builtin{Fn: builtinInsert}
+	builtins["default"] = &Builtin{Fn: builtinDefault}
+	builtins["each"] = &Builtin{Fn: builtinEach}
+	builtins["map"] = &Builtin{Fn: builtinEach}
+	builtins["par-each"] = &Builtin{Fn: builtinParEach}
+	builtins["reduce"] = &Builtin{Fn: builtinReduce}
+}
+
+// builtinThrow implementa 'throw' (alias 'error') para lanzar un error desde
+// código de usuario: `throw "mensaje"`. También acepta el registro recibido en
+// un catch, para relanzarlo tal cual: `catch err { throw $err }`.
+func builtinThrow(_ context.Context, _ Object, args ...Object) Object {
+	if len(args) == 0 {
+		return newError("uso: throw <mensaje>")
+	}
+	if record, ok := args[0].(*Json); ok && len(args) == 1 {
+		if fields, ok := record.Value.(map[string]interface{}); ok {
+			return errorFromRecord(fields)
+		}
+	}
+	var parts []string
+	for _, arg := range args {
+		parts = append(parts, arg.Inspect())
+	}
+	return newError("%s", strings.Join(parts, " "))
+}
+
+// builtinExit implementa `exit [código]`: termina el script (o la sesión) con
+// el código indicado, 0 por defecto. Viaja como un *Error con Exit para salir
+// de bloques, bucles y funciones, pero try y `||` no lo tratan.
+func builtinExit(_ context.Context, _ Object, args ...Object) Object {
+	if len(args) > 1 {
+		return newError("uso: exit [código]")
+	}
+	code := 0
+	if len(args) == 1 {
+		n, err := strconv.Atoi(args[0].Inspect())
+		if err != nil || n < 0 || n > 255 {
+			return newError("exit: se esperaba un código entre 0 y 255, se obtuvo '%s'", args[0].Inspect())
+		}
+		code = n
+	}
+	return &Error{Message: "exit", ExitCode: code, Exit: true}
+}
+
+// selectField es un campo de la salida de select: el valor de una ruta o el
+// resultado de un bloque, guardado con la clave key.
+type selectField struct {
+	key     string
+	path    []pathSegment
+	compute *Function
+	nested  bool // la ruta se reconstruye entera en la salida (select --nested)
+	source  string
 }
 
 // builtinSelect implementa el comando 'select' para proyectar campos de objetos JSON.
-func builtinSelect(input Object, args ...Object) Object {
+// Cada campo es una ruta (`.location.city`) o, con un alias, una ruta o un
+// bloque que recibe el objeto como `$it`: `select name: .name, decade: { $it.age / 10 }`.
+// Sin alias, la clave es la última de la ruta, salvo con --nested, que
+// conserva la estructura: `{"location": {"city": ...}}`.
+func builtinSelect(ctx context.Context, input Object, args ...Object) Object {
 	if input == nil {
 		return newError("select: requiere una entrada de un pipeline")
 	}
@@ -31,41 +136,58 @@ func builtinSelect(input Object, args ...Object) Object {
 	if !ok {
 		return newError("select: la entrada debe ser de tipo JSON, se obtuvo %s", input.Type())
 	}
-	if len(args) < 1 {
-		return newError("uso: select <.campo1> <.campo2> ...")
-	}
-
-	// Pre-parsear todas las rutas de los argumentos
-	var paths [][]string
-	for _, arg := range args {
-		pathArg, ok := arg.(*String)
-		if !ok {
-			return newError("select: todos los argumentos deben ser cadenas de ruta")
-		}
-		pathStr := strings.TrimPrefix(pathArg.Value, ".")
-		paths = append(paths, strings.Split(pathStr, "."))
+	fields, errObj := parseSelectFields(args)
+	if errObj != nil {
+		return errObj
 	}
 
 	// Función auxiliar para procesar un único objeto
-	processObject := func(itemData map[string]interface{}) map[string]interface{} {
+	processObject := func(itemData map[string]interface{}) (map[string]interface{}, *Error) {
 		newObj := make(map[string]interface{})
-		for _, path := range paths {
-			if value, found := accessField(itemData, path); found {
-				newKey := path[len(path)-1] // La nueva clave es la última parte de la ruta
-				newObj[newKey] = value
+		for _, field := range fields {
+			if field.compute != nil {
+				value := callClosure(ctx, field.compute, &Json{Value: itemData})
+				if err, isErr := value.(*Error); isErr {
+					return nil, err
+				}
+				newObj[field.key] = objectToNative(value)
+				continue
+			}
+			value, found := accessField(itemData, field.path)
+			if !found {
+				continue
 			}
+			if !field.nested {
+				newObj[field.key] = value
+				continue
+			}
+			nested, err := setField(newObj, field.path, value)
+			if err != nil {
+				return nil, newError("select: %v", err)
+			}
+			newObj = nested.(map[string]interface{})
 		}
-		return newObj
+		return newObj, nil
 	}
 
 	switch data := jsonInput.Value.(type) {
 	case map[string]interface{}: // Entrada es un único objeto
-		return &Json{Value: processObject(data)}
+		newObj, err := processObject(data)
+		if err != nil {
+			return err
+		}
+		return &Json{Value: newObj}
 	case []interface{}: // Entrada es un array de objetos
 		var results []interface{}
 		for _, item := range data {
+			if err := interrupted(ctx); err != nil {
+				return err
+			}
 			if itemMap, ok := item.(map[string]interface{}); ok {
-				newObj := processObject(itemMap)
+				newObj, err := processObject(itemMap)
+				if err != nil {
+					return err
+				}
 				if len(newObj) > 0 {
 					results = append(results, newObj)
 				}
@@ -77,8 +199,88 @@ func builtinSelect(input Object, args ...Object) Object {
 	}
 }
 
+// parseSelectFields interpreta los argumentos de select. Un alias es una
+// palabra terminada en ':' (`name:`), que se aplica al argumento siguiente;
+// también puede ir pegado a la ruta (`name:.name`). Dos campos que producirían
+// la misma clave son un error.
+func parseSelectFields(args []Object) ([]selectField, *Error) {
+	nested := false
+	alias := ""
+	var fields []selectField
+	for _, arg := range args {
+		if str, ok := arg.(*String); ok {
+			if str.Value == "--nested" || str.Value == "-n" {
+				nested = true
+				continue
+			}
+			if i := strings.Index(str.Value, ":"); alias == "" && i > 0 && !strings.HasPrefix(str.Value, ".") {
+				alias = str.Value[:i]
+				if str.Value[i+1:] == "" {
+					continue
+				}
+				arg = &String{Value: str.Value[i+1:]}
+			}
+		}
+		field := selectField{key: alias, source: arg.Inspect()}
+		if alias != "" {
+			field.source = alias + ": " + field.source
+		}
+		switch arg := arg.(type) {
+		case *Function:
+			if alias == "" {
+				return nil, newError("select: un campo calculado necesita un alias: nombre: %s", arg.Inspect())
+			}
+			field.compute = arg
+		case *String:
+			path, err := parsePath(arg.Value)
+			if err != nil {
+				return nil, newError("select: %v", err)
+			}
+			field.path = path
+			if alias == "" {
+				field.key = lastKey(path)
+				if field.key == "" {
+					field.key = strings.TrimPrefix(arg.Value, ".")
+				}
+				if nested {
+					for _, seg := range path {
+						if seg.kind != keySegment {
+							return nil, newError("select --nested: la ruta '%s' solo puede tener claves (usa un alias)", arg.Value)
+						}
+					}
+					field.nested = len(path) > 0
+					if field.nested {
+						field.key = path[0].key
+					}
+				}
+			}
+		default:
+			return nil, newError("select: se esperaba una ruta o un bloque, se obtuvo %s", arg.Type())
+		}
+		fields = append(fields, field)
+		alias = ""
+	}
+	if alias != "" {
+		return nil, newError("select: falta el valor del alias '%s:'", alias)
+	}
+	if len(fields) == 0 {
+		return nil, newError("uso: select [--nested] [alias:] <.campo|{ bloque }> ...")
+	}
+	// Con --nested, varias rutas pueden compartir el primer nivel (.location.city
+	// y .location.country); el resto de claves no pueden repetirse.
+	seen := make(map[string]selectField)
+	for _, field := range fields {
+		if prev, dup := seen[field.key]; dup && !(prev.nested && field.nested) {
+			return nil, newError("select: '%s' y '%s' producen la misma clave '%s'; usa un alias para distinguirlos (nombre: .ruta)",
+				prev.source, field.source, field.key)
+		}
+		seen[field.key] = field
+	}
+	return fields, nil
+}
+
 // builtinWhere implementa el comando 'where' para filtrar arrays de objetos.
-func builtinWhere(input Object, args ...Object) Object {
+func builtinWhere(ctx context.Context, input Object, args ...Object) Object {
 	if input == nil {
 		return newError("where: requiere una entrada de un pipeline")
 	}
@@ -94,26 +296,19 @@ func builtinWhere(input Object, args ...Object) Object {
 			return newError("where: solo puede filtrar arrays de objetos")
 		}
 	}
-	if len(args) != 3 {
-		return newError("uso: where <.campo> <operador> <valor>")
+	if len(args) != 1 {
+		return newError("uso: where [-i] <.campo> <operador> <valor> [and|or ...]")
 	}
-	pathArg, okPath := args[0].(*String)
-	opArg, okOp := args[1].(*String)
-	valArg, okVal := args[2].(*String)
-	if !okPath || !okOp || !okVal {
-		return newError("where: los argumentos deben ser cadenas")
+	predicate, ok := args[0].(*Predicate)
+	if !ok {
+		return newError("where: se esperaba un predicado, se obtuvo %s", args[0].Type())
 	}
-	pathStr := strings.TrimPrefix(pathArg.Value, ".")
-	path := strings.Split(pathStr, ".")
-	op := opArg.Value
-	valueStr := valArg.Value
 	var results []interface{}
 	for _, item := range items {
-		itemValue, found := accessField(item, path)
-		if !found {
-			continue
+		if err := interrupted(ctx); err != nil {
+			return err
 		}
-		match, err := evaluateCondition(itemValue, op, valueStr)
+		match, err := predicate.root.match(item)
 		if err != nil {
 			return newError("error en 'where': %v", err)
 		}
@@ -125,8 +320,14 @@ func builtinWhere(input Object, args ...Object) Object {
 }
 
 // evaluateCondition compara un valor de JSON (lhs) con un string (rhsStr).
+// Los operadores de texto comparan siempre la representación en texto de lhs.
 func evaluateCondition(lhs interface{}, op string, rhsStr string) (bool, error) {
-	if lhsFloat, ok := lhs.(float64); ok {
+	textOp := op == "contains" || op == "starts-with" || op == "ends-with"
+	// Un null no tiene orden: como un campo que falta, no cumple `>` ni `<`.
+	if lhs == nil && (op == ">" || op == "<" || op == ">=" || op == "<=") {
+		return false, nil
+	}
+	if lhsFloat, ok := lhs.(float64); ok && !textOp {
 		if rhsFloat, err := strconv.ParseFloat(rhsStr, 64); err == nil {
 			switch op {
 			case "==": return lhsFloat == rhsFloat, nil
@@ -139,7 +340,7 @@ func evaluateCondition(lhs interface{}, op string, rhsStr string) (bool, error)
 			}
 		}
 	}
-	if lhsBool, ok := lhs.(bool); ok {
+	if lhsBool, ok := lhs.(bool); ok && !textOp {
 		if rhsBool, err := strconv.ParseBool(rhsStr); err == nil {
 			switch op {
 			case "==": return lhsBool == rhsBool, nil
@@ -156,12 +357,15 @@ func evaluateCondition(lhs interface{}, op string, rhsStr string) (bool, error)
 	switch op {
 	case "==": return lhsStr == rhsStrUnquoted, nil
 	case "!=": return lhsStr != rhsStrUnquoted, nil
+	case "contains": return strings.Contains(lhsStr, rhsStrUnquoted), nil
+	case "starts-with": return strings.HasPrefix(lhsStr, rhsStrUnquoted), nil
+	case "ends-with": return strings.HasSuffix(lhsStr, rhsStrUnquoted), nil
 	default: return false, fmt.Errorf("operador no soportado para el tipo de dato: %s", op)
 	}
 }
 
 // builtinGet implementa el comando 'get' para extraer datos de objetos JSON.
-func builtinGet(input Object, args ...Object) Object {
+func builtinGet(ctx context.Context, input Object, args ...Object) Object {
 	if input == nil {
 		return newError("get: requiere una entrada de un pipeline")
 	}
@@ -176,8 +380,18 @@ func builtinGet(input Object, args ...Object) Object {
 	if !ok {
 		return newError("get: el argumento de ruta debe ser una cadena, se obtuvo %s", args[0].Type())
 	}
-	pathStr := strings.TrimPrefix(pathArg.Value, ".")
-	path := strings.Split(pathStr, ".")
+	path, err := parsePath(pathArg.Value)
+	if err != nil {
+		return newError("get: %v", err)
+	}
+	// Sobre un array, una ruta que empieza por una clave se aplica a cada
+	// elemento; una que empieza por un índice, un rango o un comodín, al array.
+	_, isArray := jsonInput.Value.([]interface{})
+	if isArray && (len(path) == 0 || path[0].kind != keySegment) {
+		result, found := accessField(jsonInput.Value, path)
+		if !found { return NULL }
+		return nativeToNshObject(result)
+	}
 	switch data := jsonInput.Value.(type) {
 	case map[string]interface{}:
 		result, found := accessField(data, path)
@@ -186,6 +400,9 @@ func builtinGet(input Object, args ...Object) Object {
 	case []interface{}:
 		var results []interface{}
 		for _, item := range data {
+			if err := interrupted(ctx); err != nil {
+				return err
+			}
 			if result, found := accessField(item, path); found {
 				results = append(results, result)
 			}
@@ -196,27 +413,39 @@ func builtinGet(input Object, args ...Object) Object {
 	}
 }
 
-// accessField es una función auxiliar para navegar recursivamente en datos JSON parseados.
-func accessField(data interface{}, path []string) (interface{}, bool) {
-	if len(path) == 0 { return data, true }
-	currentKey := path[0]
-	remainingPath := path[1:]
-	obj, ok := data.(map[string]interface{})
-	if !ok { return nil, false }
-	value, found := obj[currentKey]
-	if !found { return nil, false }
-	return accessField(value, remainingPath)
+// objectToNative convierte un objeto de nxsh en el valor de JSON equivalente.
+func objectToNative(obj Object) interface{} {
+	switch obj := obj.(type) {
+	case *Json: return obj.Value
+	case *Integer: return float64(obj.Value)
+	case *Float: return obj.Value
+	case *Boolean: return obj.Value
+	case *String: return obj.Value
+	case *Null: return nil
+	default: return obj.Inspect()
+	}
 }
 
 // nativeToNshObject convierte un valor nativo de Go (de JSON) a un objeto de nsh.
 func nativeToNshObject(v interface{}) Object {
 	if v == nil { return NULL }
+	if f, isNumber := v.(float64); isNumber { return numberToObject(f) }
+	if b, isBool := v.(bool); isBool { return nativeBoolToBooleanObject(b) }
 	if _, isMap := v.(map[string]interface{}); isMap { return &Json{Value: v} }
 	if _, isSlice := v.([]interface{}); isSlice { return &Json{Value: v} }
 	return &String{Value: fmt.Sprintf("%v", v)}
 }
 
-func builtinCd(_ Object, args ...Object) Object {
+// numberToObject convierte un número de JSON (siempre float64) en Integer si
+// no tiene parte decimal y cabe sin pérdida, o en Float en caso contrario.
+func numberToObject(f float64) Object {
+	if f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+		return &Integer{Value: int64(f)}
+	}
+	return &Float{Value: f}
+}
+
+func builtinCd(_ context.Context, _ Object, args ...Object) Object {
 	if len(args) > 1 { return newError("cd: demasiados argumentos") }
 	var path string
 	if len(args) == 0 {
@@ -232,96 +461,1094 @@ func builtinCd(_ Object, args ...Object) Object {
 	return NULL
 }
 
-func Eval(node parser.Node, env *Environment) Object {
+// EvalStatement evalúa un statement de nivel superior. La salida de sus comandos
+// externos se escribe en stdout a medida que se produce (y el resultado es NULL);
+// el resto de valores se devuelven para que quien llama los muestre.
+func EvalStatement(ctx context.Context, statement parser.Statement, env *Environment, stdout io.Writer) Object {
+	return evalStatement(ctx, statement, env, stdout)
+}
+
+func Eval(ctx context.Context, node parser.Node, env *Environment) Object {
 	switch node := node.(type) {
-	case *parser.Program: return evalProgram(node, env)
-	case *parser.ExpressionStatement: return Eval(node.Expression, env)
+	case *parser.Program: return evalProgram(ctx, node, env)
+	case *parser.ExpressionStatement:
+		if node.Background { return startJob(ctx, node, env) }
+		return Eval(ctx, node.Expression, env)
 	case *parser.LetStatement:
-		val := Eval(node.Value, env)
+		val := Eval(ctx, node.Value, env)
 		if isError(val) { return val }
 		env.Set(node.Name.Value, val)
 		return NULL
 	case *parser.Identifier: return evalIdentifier(node, env)
-	case *parser.CommandExpression: return evalCommandExpression(node, env, nil)
+	case *parser.CommandExpression, *parser.PipelineExpression, *parser.LogicalExpression, *parser.IfExpression, *parser.TryExpression:
+		return evalExpression(ctx, node.(parser.Expression), env, nil)
+	case *parser.BlockStatement: return evalBlockStatement(ctx, node, env, nil)
+	case *parser.ForStatement: return evalForStatement(ctx, node, env)
+	case *parser.BreakStatement: return BREAK
+	case *parser.DefStatement:
+		env.Set(node.Name.Value, &Function{Name: node.Name.Value, Parameters: node.Parameters, Body: node.Body, Env: env})
+		return NULL
+	case *parser.AliasStatement:
+		env.Set(node.Name.Value, &Alias{Name: node.Name.Value, Command: node.Command})
+		return NULL
+	case *parser.ReturnStatement:
+		if node.ReturnValue == nil { return &ReturnValue{Value: NULL} }
+		val := Eval(ctx, node.ReturnValue, env)
+		if isError(val) { return val }
+		return &ReturnValue{Value: val}
+	case *parser.ContinueStatement: return CONTINUE
+	case *parser.Boolean: return nativeBoolToBooleanObject(node.Value)
 	case *parser.StringLiteral: return &String{Value: node.Value}
-	case *parser.PipelineExpression:
-		leftResult := Eval(node.Left, env)
-		if isError(leftResult) { return leftResult }
-		return evalPipelineChain(node.Right, env, leftResult)
+	case *parser.IntegerLiteral: return &Integer{Value: node.Value}
+	case *parser.FloatLiteral: return &Float{Value: node.Value}
+	case *parser.PrefixExpression:
+		right := Eval(ctx, node.Right, env)
+		if isError(right) { return right }
+		return evalPrefixExpression(node.Operator, right)
+	case *parser.InfixExpression:
+		left := Eval(ctx, node.Left, env)
+		if isError(left) { return left }
+		right := Eval(ctx, node.Right, env)
+		if isError(right) { return right }
+		return evalInfixExpression(node.Operator, left, right)
+	case *parser.Variable: return evalVariable(ctx, node, env)
+	case *parser.PredicateExpression: return evalPredicateExpression(ctx, node, env)
+	case *parser.ArrayLiteral: return evalArrayLiteral(ctx, node, env)
+	case *parser.EmptyRecordLiteral: return &Json{Value: map[string]interface{}{}}
+	case *parser.CallExpression: return evalCallExpression(ctx, node, env)
+	case *parser.ClosureLiteral:
+		return &Function{Parameters: node.Parameters, Body: node.Body, Env: env}
+	case *parser.InterpolatedString: return evalInterpolatedString(ctx, node, env)
 	}
 	return newError("tipo de nodo no soportado: %T", node)
 }
 
-func evalPipelineChain(node parser.Expression, env *Environment, input Object) Object {
+func evalPrefixExpression(operator string, right Object) Object {
+	if operator != "-" {
+		return newError("operador desconocido: %s%s", operator, right.Type())
+	}
+	switch right := right.(type) {
+	case *Integer:
+		return &Integer{Value: -right.Value}
+	case *Float:
+		return &Float{Value: -right.Value}
+	default:
+		return newError("operador desconocido: -%s", right.Type())
+	}
+}
+
+func evalInfixExpression(operator string, left, right Object) Object {
+	switch {
+	case left.Type() == INTEGER_OBJ && right.Type() == INTEGER_OBJ:
+		return evalIntegerInfixExpression(operator, left.(*Integer).Value, right.(*Integer).Value)
+	case isNumber(left) && isNumber(right):
+		return evalFloatInfixExpression(operator, toFloat(left), toFloat(right))
+	case left.Type() == STRING_OBJ && right.Type() == STRING_OBJ:
+		return evalStringInfixExpression(operator, left.(*String).Value, right.(*String).Value)
+	case operator == "==":
+		return nativeBoolToBooleanObject(objectsEqual(left, right))
+	case operator == "!=":
+		return nativeBoolToBooleanObject(!objectsEqual(left, right))
+	default:
+		return newError("tipos incompatibles: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+func evalIntegerInfixExpression(operator string, left, right int64) Object {
+	switch operator {
+	case "..":
+		return evalRange(left, right)
+	case "+":
+		return &Integer{Value: left + right}
+	case "-":
+		return &Integer{Value: left - right}
+	case "*":
+		return &Integer{Value: left * right}
+	case "/":
+		if right == 0 {
+			return newError("división por cero")
+		}
+		// La división entre enteros solo es entera si es exacta: 7 / 2 = 3.5.
+		if left%right != 0 {
+			return &Float{Value: float64(left) / float64(right)}
+		}
+		return &Integer{Value: left / right}
+	case "%":
+		if right == 0 {
+			return newError("división por cero")
+		}
+		return &Integer{Value: left % right}
+	case "<":
+		return nativeBoolToBooleanObject(left < right)
+	case ">":
+		return nativeBoolToBooleanObject(left > right)
+	case "<=":
+		return nativeBoolToBooleanObject(left <= right)
+	case ">=":
+		return nativeBoolToBooleanObject(left >= right)
+	case "==":
+		return nativeBoolToBooleanObject(left == right)
+	case "!=":
+		return nativeBoolToBooleanObject(left != right)
+	default:
+		return newError("operador desconocido: INTEGER %s INTEGER", operator)
+	}
+}
+
+// evalRange construye el array de enteros de un rango inclusivo, ascendente o
+// descendente: 1..3 es [1, 2, 3] y 3..1 es [3, 2, 1].
+func evalRange(from, to int64) Object {
+	step := int64(1)
+	if from > to {
+		step = -1
+	}
+	items := []interface{}{}
+	for i := from; ; i += step {
+		items = append(items, float64(i))
+		if i == to {
+			break
+		}
+	}
+	return &Json{Value: items}
+}
+
+func evalFloatInfixExpression(operator string, left, right float64) Object {
+	switch operator {
+	case "+":
+		return &Float{Value: left + right}
+	case "-":
+		return &Float{Value: left - right}
+	case "*":
+		return &Float{Value: left * right}
+	case "/":
+		if right == 0 {
+			return newError("división por cero")
+		}
+		return &Float{Value: left / right}
+	case "%":
+		if right == 0 {
+			return newError("división por cero")
+		}
+		return &Float{Value: math.Mod(left, right)}
+	case "<":
+		return nativeBoolToBooleanObject(left < right)
+	case ">":
+		return nativeBoolToBooleanObject(left > right)
+	case "<=":
+		return nativeBoolToBooleanObject(left <= right)
+	case ">=":
+		return nativeBoolToBooleanObject(left >= right)
+	case "==":
+		return nativeBoolToBooleanObject(left == right)
+	case "!=":
+		return nativeBoolToBooleanObject(left != right)
+	default:
+		return newError("operador desconocido: FLOAT %s FLOAT", operator)
+	}
+}
+
+func evalStringInfixExpression(operator string, left, right string) Object {
+	switch operator {
+	case "+":
+		return &String{Value: left + right}
+	case "<":
+		return nativeBoolToBooleanObject(left < right)
+	case ">":
+		return nativeBoolToBooleanObject(left > right)
+	case "<=":
+		return nativeBoolToBooleanObject(left <= right)
+	case ">=":
+		return nativeBoolToBooleanObject(left >= right)
+	case "==":
+		return nativeBoolToBooleanObject(left == right)
+	case "!=":
+		return nativeBoolToBooleanObject(left != right)
+	default:
+		return newError("operador desconocido: STRING %s STRING", operator)
+	}
+}
+
+func isNumber(obj Object) bool {
+	return obj.Type() == INTEGER_OBJ || obj.Type() == FLOAT_OBJ
+}
+
+// toFloat devuelve el valor de un Integer o Float como float64.
+func toFloat(obj Object) float64 {
+	if i, ok := obj.(*Integer); ok {
+		return float64(i.Value)
+	}
+	return obj.(*Float).Value
+}
+
+// evalStatement evalúa un statement. Si stdout no es nil, la salida de los
+// comandos externos se escribe ahí en lugar de capturarse como valor. Como los
+// comandos `true` y `false` de sh, un statement que da un booleano deja `$?` a
+// 0 o a 1: `false && echo no; echo $?` muestra 1.
+func evalStatement(ctx context.Context, statement parser.Statement, env *Environment, stdout io.Writer) Object {
+	if es, ok := statement.(*parser.ExpressionStatement); ok && !es.Background {
+		result := evalExpression(ctx, es.Expression, env, stdout)
+		if _, isBool := result.(*Boolean); isBool {
+			setStatus(ctx, env, es.Expression.String(), result)
+		}
+		return result
+	}
+	return Eval(ctx, statement, env)
+}
+
+// evalExpression evalúa una expresión cuya salida externa puede ir a stdout
+// (ver evalStatement). Las expresiones sin comandos se delegan en Eval.
+func evalExpression(ctx context.Context, node parser.Expression, env *Environment, stdout io.Writer) Object {
 	switch node := node.(type) {
-	case *parser.CommandExpression: return evalCommandExpression(node, env, input)
+	case *parser.CommandExpression:
+		return evalCommandExpression(ctx, node, env, nil, stdout)
 	case *parser.PipelineExpression:
-		intermediateResult := evalPipelineChain(node.Left, env, input)
-		if isError(intermediateResult) { return intermediateResult }
-		return evalPipelineChain(node.Right, env, intermediateResult)
-	default: return newError("lado derecho del pipe inválido: se esperaba un comando")
+		return evalPipeline(ctx, node, env, stdout)
+	case *parser.LogicalExpression:
+		return evalLogicalExpression(ctx, node, env, stdout)
+	case *parser.IfExpression:
+		return evalIfExpression(ctx, node, env, stdout)
+	case *parser.TryExpression:
+		return evalTryExpression(ctx, node, env, stdout)
+	default:
+		return Eval(ctx, node, env)
+	}
+}
+
+// evalBlockStatement evalúa los statements de un bloque y devuelve el valor del
+// último. Los anteriores se ejecutan solo por sus efectos, así que la salida de
+// sus comandos externos va directa a la terminal en vez de descartarse.
+func evalBlockStatement(ctx context.Context, block *parser.BlockStatement, env *Environment, stdout io.Writer) Object {
+	var result Object = NULL
+	for i, statement := range block.Statements {
+		out := io.Writer(os.Stdout)
+		if i == len(block.Statements)-1 {
+			out = stdout
+		}
+		result = evalStatement(ctx, statement, env, out)
+		if isError(result) || result == BREAK || result == CONTINUE || result.Type() == RETURN_VALUE_OBJ {
+			return result
+		}
+	}
+	return result
+}
+
+// evalForStatement ejecuta el cuerpo del bucle una vez por elemento. Como en
+// los bloques de `if`, los `let` del cuerpo se guardan en el entorno que
+// contiene el bucle; solo la variable del bucle es propia de cada iteración
+// (ver newBlockEnvironment). El bucle no produce valor: la salida de los
+// comandos del cuerpo va a la terminal.
+func evalForStatement(ctx context.Context, fs *parser.ForStatement, env *Environment) Object {
+	iterable := Eval(ctx, fs.Iterable, env)
+	if isError(iterable) {
+		return iterable
+	}
+	items, err := iterableItems(iterable)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		if err := interrupted(ctx); err != nil {
+			return err
+		}
+		loopEnv := newBlockEnvironment(env, fs.Variable.Value, item)
+		result := evalBlockStatement(ctx, fs.Body, loopEnv, os.Stdout)
+		if isError(result) || result.Type() == RETURN_VALUE_OBJ {
+			return result
+		}
+		if result == BREAK {
+			break
+		}
 	}
+	return NULL
+}
+
+// iterableItems devuelve los elementos sobre los que itera un `for`: los de un
+// array JSON, las líneas de un String o ninguno para null.
+func iterableItems(obj Object) ([]Object, *Error) {
+	switch obj := obj.(type) {
+	case *Null:
+		return nil, nil
+	case *String:
+		var items []Object
+		for _, line := range splitLines(obj.Value) {
+			items = append(items, &String{Value: line})
+		}
+		return items, nil
+	case *Json:
+		array, ok := obj.Value.([]interface{})
+		if !ok {
+			return nil, newError("for: solo se puede iterar sobre arrays JSON, se obtuvo un objeto")
+		}
+		items := make([]Object, 0, len(array))
+		for _, v := range array {
+			items = append(items, nativeToNshObject(v))
+		}
+		return items, nil
+	default:
+		return nil, newError("for: no se puede iterar sobre %s", obj.Type())
+	}
+}
+
+// splitLines divide un texto en líneas, ignorando el salto final.
+func splitLines(s string) []string {
+	s = strings.TrimSuffix(s, "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+func evalIfExpression(ctx context.Context, ie *parser.IfExpression, env *Environment, stdout io.Writer) Object {
+	condition := evalCondition(ctx, ie.Condition, env)
+	if isError(condition) {
+		return condition
+	}
+	if condition == TRUE {
+		return evalBlockStatement(ctx, ie.Consequence, env, stdout)
+	} else if ie.Alternative != nil {
+		return evalBlockStatement(ctx, ie.Alternative, env, stdout)
+	}
+	return NULL
+}
+
+// evalTryExpression evalúa el bloque try y, si devuelve un error, el bloque
+// catch con el error disponible como registro en la variable indicada.
+// Sin bloque catch el error simplemente se descarta.
+func evalTryExpression(ctx context.Context, te *parser.TryExpression, env *Environment, stdout io.Writer) Object {
+	result := evalBlockStatement(ctx, te.Block, env, stdout)
+	err, ok := result.(*Error)
+	if !ok || err.Exit {
+		return result
+	}
+	if te.CatchBlock == nil {
+		return NULL
+	}
+	catchEnv := env
+	if te.CatchVariable != nil {
+		catchEnv = newBlockEnvironment(env, te.CatchVariable.Value, errorRecord(err))
+	}
+	return evalBlockStatement(ctx, te.CatchBlock, catchEnv, stdout)
 }
 
-func evalProgram(program *parser.Program, env *Environment) Object {
+// errorRecord convierte un error en el registro que recibe un catch:
+// {"message", "command", "exit_code", "stderr"}. Los errores que no vienen de
+// un proceso tienen exit_code 1, como los builtins fallidos en cualquier shell.
+func errorRecord(err *Error) *Json {
+	record := map[string]interface{}{
+		"message":   err.Message,
+		"command":   nil,
+		"exit_code": float64(1),
+		"stderr":    err.Stderr,
+	}
+	if err.Command != "" {
+		record["command"] = err.Command
+	}
+	if err.ExitCode != 0 {
+		record["exit_code"] = float64(err.ExitCode)
+	}
+	return &Json{Value: record}
+}
+
+// errorFromRecord es la inversa de errorRecord, usada para relanzar errores.
+func errorFromRecord(fields map[string]interface{}) *Error {
+	err := &Error{Message: fmt.Sprintf("%v", fields["message"])}
+	if command, ok := fields["command"].(string); ok {
+		err.Command = command
+	}
+	if code, ok := fields["exit_code"].(float64); ok {
+		err.ExitCode = int(code)
+	}
+	if stderr, ok := fields["stderr"].(string); ok {
+		err.Stderr = stderr
+	}
+	return err
+}
+
+// evalCondition evalúa la condición de un `if` y devuelve TRUE o FALSE.
+// Si termina en un comando externo cuenta su éxito o fallo (como en cualquier
+// shell); en otro caso se usa la veracidad del valor (ver isTruthy). En
+// `a && b` y `a || b` cada lado se evalúa como una condición.
+func evalCondition(ctx context.Context, node parser.Expression, env *Environment) Object {
+	if le, ok := node.(*parser.LogicalExpression); ok {
+		left := evalCondition(ctx, le.Left, env)
+		if isError(left) || (left == TRUE) == (le.Operator == "||") {
+			return left
+		}
+		return evalCondition(ctx, le.Right, env)
+	}
+	result := Eval(ctx, node, env)
+	if isExternalCommand(node, env) {
+		// Un error que no viene de la ejecución del comando (ej. una variable
+		// no definida en sus argumentos) no es un fallo, sino un error.
+		if err, ok := result.(*Error); ok && err.ExitCode == 0 {
+			return err
+		}
+		return nativeBoolToBooleanObject(!isError(result))
+	}
+	if isError(result) {
+		return result
+	}
+	return nativeBoolToBooleanObject(isTruthy(result))
+}
+
+// isExternalCommand indica si el último comando de un pipeline es un programa
+// externo, es decir, si su nombre no es una variable ni un builtin.
+func isExternalCommand(node parser.Expression, env *Environment) bool {
+	piped := false
+	for {
+		pipe, ok := node.(*parser.PipelineExpression)
+		if !ok {
+			break
+		}
+		node, piped = pipe.Right, true
+	}
+	cmd, ok := node.(*parser.CommandExpression)
+	return ok && isExternalStage(cmd, env, piped)
+}
+
+// isExternalStage indica si un comando se ejecuta como programa externo: su
+// nombre, una vez expandidos los alias, no es una variable ni un builtin, o es
+// un builtin que en este uso deja paso al programa (ver Builtin.External).
+// piped indica si el comando recibe la entrada de un pipeline.
+func isExternalStage(cmdExpr *parser.CommandExpression, env *Environment, piped bool) bool {
+	cmdExpr = expandAlias(cmdExpr, env)
+	name := cmdExpr.Name.String()
+	if val, isVar := env.Get(name); isVar && val.Type() != ALIAS_OBJ {
+		return false
+	}
+	builtin, isBuiltin := builtins[name]
+	if !isBuiltin {
+		return true
+	}
+	return builtin.External != nil && builtin.External(cmdExpr.Args, piped)
+}
+
+// onlyFlags indica si todos los argumentos son palabras literales de flags.
+// Los builtins que tienen el nombre de un programa lo usan para dejarle paso
+// cuando reciben opciones que no conocen.
+func onlyFlags(args []parser.Expression, flags ...string) bool {
+	for _, arg := range args {
+		ident, ok := arg.(*parser.Identifier)
+		if !ok {
+			return false
+		}
+		known := false
+		for _, flag := range flags {
+			known = known || ident.Value == flag
+		}
+		if !known {
+			return false
+		}
+	}
+	return true
+}
+
+// isTruthy define la veracidad de un valor: null, false, 0, "" y los arrays u
+// objetos JSON vacíos son falsos; todo lo demás es verdadero.
+func isTruthy(obj Object) bool {
+	switch obj := obj.(type) {
+	case *Null:
+		return false
+	case *Boolean:
+		return obj.Value
+	case *Integer:
+		return obj.Value != 0
+	case *Float:
+		return obj.Value != 0
+	case *String:
+		return obj.Value != ""
+	case *Json:
+		return isTruthyNative(obj.Value)
+	default:
+		return true
+	}
+}
+
+// isTruthyNative aplica las reglas de isTruthy a un valor JSON decodificado.
+func isTruthyNative(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	case []interface{}:
+		return len(v) > 0
+	case map[string]interface{}:
+		return len(v) > 0
+	default:
+		return true
+	}
+}
+
+func nativeBoolToBooleanObject(input bool) *Boolean {
+	if input {
+		return TRUE
+	}
+	return FALSE
+}
+
+// objectsEqual compara dos valores para == y !=. Los JSON se comparan en
+// profundidad; el resto de escalares por su representación, de modo que
+// `"5" == 5` y `"true" == true` son ciertos, como en una shell.
+func objectsEqual(left, right Object) bool {
+	leftJson, leftOk := left.(*Json)
+	rightJson, rightOk := right.(*Json)
+	if leftOk && rightOk {
+		return reflect.DeepEqual(leftJson.Value, rightJson.Value)
+	}
+	if leftOk || rightOk {
+		return false
+	}
+	return left.Inspect() == right.Inspect()
+}
+
+// evalPipeline evalúa un pipeline etapa a etapa. Las etapas consecutivas que son
+// comandos externos se ejecutan a la vez, unidas por pipes del sistema, de modo
+// que `tail -f log | grep ERROR` produce salida mientras corre. Su salida solo se
+// convierte en String o Json cuando la consume un builtin o hay que capturarla.
+func evalPipeline(ctx context.Context, node *parser.PipelineExpression, env *Environment, stdout io.Writer) Object {
+	stages := pipelineStages(node)
+	var input Object
+	for i := 0; i < len(stages); {
+		var external []*parser.CommandExpression
+		for _, stage := range stages[i:] {
+			cmdExpr, ok := stage.(*parser.CommandExpression)
+			if !ok || !isExternalStage(cmdExpr, env, i+len(external) > 0) {
+				break
+			}
+			external = append(external, cmdExpr)
+		}
+		n := len(external)
+		if n == 0 {
+			n = 1
+		}
+		var out io.Writer
+		if i+n == len(stages) {
+			out = stdout
+		}
+
+		if len(external) > 0 {
+			input = evalExternalPipeline(ctx, external, env, input, out)
+		} else if i == 0 {
+			input = Eval(ctx, stages[0], env)
+		} else if cmdExpr, ok := stages[i].(*parser.CommandExpression); ok {
+			input = evalCommandExpression(ctx, cmdExpr, env, input, out)
+		} else {
+			return newError("lado derecho del pipe inválido: se esperaba un comando")
+		}
+		if isError(input) { return input }
+		i += n
+	}
+	return input
+}
+
+// evalLogicalExpression evalúa `a && b` y `a || b`. El lado izquierdo falla si
+// devuelve un error (como un comando con código de salida distinto de cero) o
+// false. Con `||` el error del lado izquierdo se descarta, porque ya se ha tratado.
+func evalLogicalExpression(ctx context.Context, node *parser.LogicalExpression, env *Environment, stdout io.Writer) Object {
+	left := evalExpression(ctx, node.Left, env, stdout)
+	if err, ok := left.(*Error); ok && err.Exit {
+		return left
+	}
+	failed := isError(left) || left == FALSE
+	if failed == (node.Operator == "&&") {
+		return left
+	}
+	return evalExpression(ctx, node.Right, env, stdout)
+}
+
+// pipelineStages aplana `a | b | c` en la lista de sus etapas.
+func pipelineStages(node *parser.PipelineExpression) []parser.Expression {
+	stages := []parser.Expression{node.Left}
+	for {
+		right, ok := node.Right.(*parser.PipelineExpression)
+		if !ok {
+			return append(stages, node.Right)
+		}
+		stages = append(stages, right.Left)
+		node = right
+	}
+}
+
+func evalProgram(ctx context.Context, program *parser.Program, env *Environment) Object {
 	var result Object
 	for _, statement := range program.Statements {
-		result = Eval(statement, env)
+		result = evalStatement(ctx, statement, env, nil)
 		if err, ok := result.(*Error); ok { return err }
 	}
 	return result
 }
 
+// applyFunction llama a una función de usuario con la entrada del pipeline y
+// sus argumentos. Cada llamada tiene su propio entorno, anidado en el de la
+// definición, con los parámetros y la entrada del pipeline en `$in`.
+func applyFunction(ctx context.Context, fn *Function, input Object, args []Object) Object {
+	if len(args) != len(fn.Parameters) {
+		return newError("%s: se esperaban %d argumentos, se obtuvieron %d", fn.Name, len(fn.Parameters), len(args))
+	}
+	if input == nil {
+		input = NULL
+	}
+	env := NewEnclosedEnvironment(fn.Env)
+	env.Set("in", input)
+	for i, param := range fn.Parameters {
+		env.Set(param.Value, args[i])
+	}
+	return unwrapReturnValue(evalBlockStatement(ctx, fn.Body, env, nil))
+}
+
+// callClosure llama a un bloque `{ |a, b| ... }` con los argumentos args. El
+// primero está siempre disponible como `$it`, y el bloque puede declarar menos
+// parámetros de los que recibe, pero no más.
+func callClosure(ctx context.Context, fn *Function, args ...Object) Object {
+	var it Object
+	if len(args) > 0 {
+		it = args[0]
+	}
+	return callClosureWithIt(ctx, fn, it, args...)
+}
+
+// callClosureWithIt es como callClosure, pero `$it` es it en lugar del primer
+// argumento (en reduce, el primero es el acumulador y `$it` el elemento).
+func callClosureWithIt(ctx context.Context, fn *Function, it Object, args ...Object) Object {
+	if len(fn.Parameters) > len(args) {
+		return newError("el bloque declara %d parámetros, pero recibe %d", len(fn.Parameters), len(args))
+	}
+	env := NewEnclosedEnvironment(fn.Env)
+	if it != nil {
+		env.Set("it", it)
+	}
+	for i, param := range fn.Parameters {
+		env.Set(param.Value, args[i])
+	}
+	return unwrapReturnValue(evalBlockStatement(ctx, fn.Body, env, nil))
+}
+
+func unwrapReturnValue(obj Object) Object {
+	if returnValue, ok := obj.(*ReturnValue); ok {
+		return returnValue.Value
+	}
+	return obj
+}
+
+// evalIdentifier evalúa una palabra suelta. Es siempre texto literal: las
+// variables se referencian con $name (ver evalVariable).
 func evalIdentifier(node *parser.Identifier, env *Environment) Object {
-	if val, ok := env.Get(node.Value); ok { return val }
-	if builtin, ok := builtins[node.Value]; ok { return builtin }
 	return &String{Value: node.Value}
 }
 
-func evalCommandExpression(cmdExpr *parser.CommandExpression, env *Environment, input Object) Object {
+// evalVariable resuelve $name y, si hay ruta, extrae el campo con la misma
+// semántica que `get`. Sobre valores que no son JSON la ruta es texto literal,
+// de modo que "$name.txt" funciona como en cualquier shell. Si la variable no
+// existe en nxsh se busca en las del entorno del proceso (`$HOME`, `$PATH`).
+func evalVariable(ctx context.Context, node *parser.Variable, env *Environment) Object {
+	val, ok := env.Get(node.Name)
+	if !ok {
+		value, isEnv := os.LookupEnv(node.Name)
+		if !isEnv {
+			return newError("variable no definida: $%s", node.Name)
+		}
+		val = &String{Value: value}
+	}
+	if node.Path == "" {
+		return val
+	}
+	if _, isJson := val.(*Json); !isJson {
+		return &String{Value: val.Inspect() + node.Path}
+	}
+	return builtinGet(ctx, val, &String{Value: node.Path})
+}
+
+// evalInterpolatedString concatena los trozos de texto con el valor de cada variable.
+func evalInterpolatedString(ctx context.Context, node *parser.InterpolatedString, env *Environment) Object {
+	var out strings.Builder
+	for _, part := range node.Parts {
+		val := Eval(ctx, part, env)
+		if isError(val) {
+			return val
+		}
+		out.WriteString(val.Inspect())
+	}
+	return &String{Value: out.String()}
+}
+
+// evalArrayLiteral evalúa los elementos de una lista y la devuelve como un array JSON.
+func evalArrayLiteral(ctx context.Context, node *parser.ArrayLiteral, env *Environment) Object {
+	elements := make([]interface{}, 0, len(node.Elements))
+	for _, el := range node.Elements {
+		val := Eval(ctx, el, env)
+		if isError(val) {
+			return val
+		}
+		elements = append(elements, objectToNative(val))
+	}
+	return &Json{Value: elements}
+}
+
+// evalCommandExpression ejecuta un comando con la entrada del pipeline (o nil).
+// Si stdout no es nil, la salida de un comando externo se escribe ahí y el
+// resultado es NULL; si no, se captura y se devuelve como String o Json.
+func evalCommandExpression(ctx context.Context, cmdExpr *parser.CommandExpression, env *Environment, input Object, stdout io.Writer) Object {
+	if isExternalStage(cmdExpr, env, input != nil) {
+		return evalExternalPipeline(ctx, []*parser.CommandExpression{cmdExpr}, env, input, stdout)
+	}
+	cmdExpr = expandAlias(cmdExpr, env)
+	redir, errObj := openRedirects(ctx, cmdExpr, env)
+	if errObj != nil { return errObj }
+	defer redir.close()
+	if redir.stdin != nil {
+		input = redir.readInput()
+		if isError(input) { return input }
+	}
+	result := evalInternalCommand(ctx, cmdExpr, env, input)
+	// La salida de un comando interno redirigida con `>` se serializa en el
+	// fichero, y su error va al fichero de `2>`.
+	if err, ok := result.(*Error); ok && redir.stderr != nil && !err.Exit {
+		return redir.writeError(err)
+	}
+	if redir.stdout != nil && !isError(result) {
+		return redir.writeOutput(result)
+	}
+	return result
+}
+
+// evalInternalCommand ejecuta un comando que no es un programa externo: una
+// variable, una función de usuario o un builtin.
+func evalInternalCommand(ctx context.Context, cmdExpr *parser.CommandExpression, env *Environment, input Object) Object {
+	var fn *Function
 	if ident, ok := cmdExpr.Name.(*parser.Identifier); ok {
-		if val, exists := env.Get(ident.Value); exists {
-			if len(cmdExpr.Args) > 0 {
-				return newError("la variable '%s' no es un comando y no acepta argumentos", ident.Value)
+		// Un alias que se expande a sí mismo (`alias ls = ls -F`) ejecuta el comando real.
+		if val, exists := env.Get(ident.Value); exists && val.Type() != ALIAS_OBJ {
+			fn, ok = val.(*Function)
+			if !ok {
+				if len(cmdExpr.Args) > 0 {
+					return newError("la variable '%s' no es un comando y no acepta argumentos", ident.Value)
+				}
+				return val
 			}
-			return val
 		}
 	}
-	nameObj := Eval(cmdExpr.Name, env)
+	nameObj := Eval(ctx, cmdExpr.Name, env)
 	if isError(nameObj) { return nameObj }
+	args, errObj := evalCommandArgs(ctx, cmdExpr, env)
+	if errObj != nil { return errObj }
+	cmdName := nameObj.Inspect()
+	if fn != nil { return setStatus(ctx, env, commandLine(cmdName, args), applyFunction(ctx, fn, input, args)) }
+	builtin := builtins[cmdName]
+	jobFromContext(ctx).markStarted()
+	result := builtin.Fn(ctx, input, args...)
+	if err, ok := result.(*Error); ok && err.Command == "" {
+		err.Command = cmdName
+	}
+	return setStatus(ctx, env, commandLine(cmdName, args), result)
+}
+
+// commandLine reconstruye la línea de un comando interno a partir de sus argumentos evaluados.
+func commandLine(cmdName string, args []Object) string {
+	parts := []string{cmdName}
+	for _, arg := range args {
+		parts = append(parts, arg.Inspect())
+	}
+	return strings.Join(parts, " ")
+}
+
+// ExitStatus devuelve el código de salida que corresponde a un resultado: 0
+// salvo para un error o `false`. Un error sin código de salida (ej. el de un
+// builtin) cuenta como 1, salvo el de `exit 0`.
+func ExitStatus(result Object) int {
+	if result == FALSE {
+		return 1
+	}
+	err, ok := result.(*Error)
+	if !ok {
+		return 0
+	}
+	if err.ExitCode == 0 && !err.Exit {
+		return 1
+	}
+	return err.ExitCode
+}
+
+// setStatus guarda el código de salida de un comando en `$?` y en el registro
+// `last-status` del entorno de la sesión, y devuelve result sin cambios.
+func setStatus(ctx context.Context, env *Environment, command string, result Object) Object {
+	// Los trabajos en segundo plano y los bloques de par-each no cambian el
+	// estado de la sesión.
+	if inBackground(ctx) {
+		return result
+	}
+	code := ExitStatus(result)
+	env.SetGlobal("?", &Integer{Value: int64(code)})
+	env.SetGlobal("last-status", &Json{Value: map[string]interface{}{
+		"command":   command,
+		"exit_code": float64(code),
+		"success":   code == 0,
+	}})
+	return result
+}
+
+// evalCommandArgs evalúa los argumentos de un comando.
+func evalCommandArgs(ctx context.Context, cmdExpr *parser.CommandExpression, env *Environment) ([]Object, Object) {
 	var args []Object
 	for _, argExpr := range cmdExpr.Args {
-		evaluatedArg := Eval(argExpr, env)
-		if isError(evaluatedArg) { return evaluatedArg }
+		evaluatedArg := Eval(ctx, argExpr, env)
+		if isError(evaluatedArg) { return nil, evaluatedArg }
 		args = append(args, evaluatedArg)
 	}
-	if builtin, ok := nameObj.(*Builtin); ok { return builtin.Fn(input, args...) }
-	cmdName := nameObj.Inspect()
+	return args, nil
+}
+
+// newExternalCommand prepara, sin arrancarlo, el proceso de un comando externo.
+func newExternalCommand(ctx context.Context, cmdName string, cmdExpr *parser.CommandExpression, args []Object) *exec.Cmd {
 	var argStrings []string
-	for _, arg := range args {
-		argStrings = append(argStrings, arg.Inspect())
+	for i, arg := range args {
+		argStrings = append(argStrings, externalArg(cmdExpr.Args[i], arg))
 	}
-	cmd := exec.Command(cmdName, argStrings...)
+	return exec.CommandContext(ctx, cmdName, argStrings...)
+}
+
+// evalExternalPipeline ejecuta una secuencia de comandos externos consecutivos
+// de un pipeline (ver runExternal).
+func evalExternalPipeline(ctx context.Context, cmdExprs []*parser.CommandExpression, env *Environment, input Object, stdout io.Writer) Object {
+	var cmds []*exec.Cmd
+	var redirs []*redirection
+	// Los procesos no usan ctx directamente: si el pipeline se detiene con
+	// Ctrl-Z pasa a ser un trabajo, que sobrevive al statement y cierra las
+	// redirecciones cuando termina.
+	pipeCtx := newPipelineContext(ctx)
+	defer func() {
+		if pipeCtx.release() {
+			closeRedirects(redirs)
+		}
+	}()
+	for _, cmdExpr := range cmdExprs {
+		cmdExpr = expandAlias(cmdExpr, env)
+		nameObj := Eval(ctx, cmdExpr.Name, env)
+		if isError(nameObj) { return nameObj }
+		args, errObj := evalCommandArgs(ctx, cmdExpr, env)
+		if errObj != nil { return errObj }
+		redir, redirErr := openRedirects(ctx, cmdExpr, env)
+		if redirErr != nil { return redirErr }
+		redirs = append(redirs, redir)
+		cmds = append(cmds, newExternalCommand(pipeCtx, nameObj.Inspect(), cmdExpr, args))
+	}
+	last := cmds[len(cmds)-1]
+	return setStatus(ctx, env, strings.Join(last.Args, " "), runExternal(ctx, pipeCtx, cmds, redirs, input, stdout))
+}
+
+// runExternal ejecuta a la vez uno o más procesos externos, conectando la salida
+// de cada uno con la entrada del siguiente mediante os.Pipe. El primero lee de
+// input (o de la terminal si es nil) y el último escribe en stdout o, si es nil,
+// en un buffer que se devuelve como String o Json. Las redirecciones de cada
+// proceso (redirs) tienen prioridad sobre esas conexiones. Como en cualquier
+// shell, el resultado del pipeline es el del último proceso. Todos los procesos
+// comparten un grupo (ver setProcessGroup); si el pipeline en primer plano se
+// detiene con Ctrl-Z, pasa a ser un trabajo detenido.
+func runExternal(ctx context.Context, pipeCtx *pipelineContext, cmds []*exec.Cmd, redirs []*redirection, input Object, stdout io.Writer) Object {
+	first, last := cmds[0], cmds[len(cmds)-1]
+	job := jobFromContext(ctx)
+	background := inBackground(ctx)
 	if input != nil {
-		cmd.Stdin = bytes.NewReader([]byte(input.Inspect()))
-	} else {
-		cmd.Stdin = os.Stdin
+		first.Stdin = strings.NewReader(input.Inspect())
+	} else if !background {
+		// Un trabajo en segundo plano (o un bloque de par-each) no lee de la
+		// terminal: su stdin queda vacío.
+		first.Stdin = os.Stdin
 	}
 	var out bytes.Buffer
-	cmd.Stdout = &out
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		return newError("error ejecutando '%s': %v", cmdName, err)
+	last.Stdout = &out
+	if stdout != nil {
+		last.Stdout = stdout
+	}
+
+	var pipeEnds []*os.File
+	closePipes := func() {
+		for _, f := range pipeEnds {
+			f.Close()
+		}
 	}
-	output := out.Bytes()
+	for i := 0; i < len(cmds)-1; i++ {
+		r, w, err := os.Pipe()
+		if err != nil {
+			closePipes()
+			return newError("error creando el pipe: %v", err)
+		}
+		cmds[i].Stdout = w
+		cmds[i+1].Stdin = r
+		pipeEnds = append(pipeEnds, r, w)
+	}
+
+	// stderr se muestra en la terminal y además se guarda para los registros de error.
+	stderrs := make([]bytes.Buffer, len(cmds))
+	for i, cmd := range cmds {
+		redir := redirs[i]
+		stdout := cmd.Stdout // la salida sin redirigir: el pipe, el buffer o la terminal
+		if redir.stdin != nil {
+			cmd.Stdin = redir.stdin
+		}
+		if redir.stdout != nil {
+			cmd.Stdout = redir.stdout
+		}
+		// Con `2>&1` stderr comparte el descriptor de la salida (y no se guarda
+		// aparte), para que ambos flujos se escriban en orden.
+		switch {
+		case redir.stderrToStdout:
+			cmd.Stderr = stdout
+		case redir.stderr != nil && redir.stderr == redir.stdout:
+			cmd.Stderr = cmd.Stdout
+		case redir.stderr != nil:
+			cmd.Stderr = io.MultiWriter(redir.stderr, &stderrs[i])
+		default:
+			cmd.Stderr = io.MultiWriter(os.Stderr, &stderrs[i])
+		}
+	}
+
+	foreground := !background && jobControlEnabled()
+	pgid := 0
+	for i, cmd := range cmds {
+		setProcessGroup(cmd, pgid, background)
+		if err := cmd.Start(); err != nil {
+			closePipes()
+			for _, started := range cmds[:i] {
+				started.Process.Kill()
+				started.Wait()
+			}
+			if foreground && i > 0 {
+				reclaimTerminal()
+			}
+			cmdErr := commandError(cmd, err, stderrs[i].String())
+			if redirs[i].stderr != nil {
+				return redirs[i].writeError(cmdErr)
+			}
+			return cmdErr
+		}
+		if pgid == 0 {
+			pgid = cmd.Process.Pid
+		}
+		job.track(cmd.Process, pgid)
+	}
+	job.markStarted()
+	// Los procesos ya tienen su copia de los pipes; cerramos la nuestra para que
+	// cada lector reciba EOF cuando termine el proceso que le escribe.
+	closePipes()
+
+	finish := func(job *Job) Object {
+		var lastErr error
+		for _, cmd := range cmds {
+			lastErr = cmd.Wait()
+			job.untrack(cmd.Process)
+		}
+		if lastErr != nil {
+			// Un proceso terminado por la cancelación del pipeline (Ctrl-C) cuenta
+			// como interrumpido.
+			if err := interrupted(pipeCtx); err != nil {
+				err.Command = strings.Join(last.Args, " ")
+				return err
+			}
+			// Con stderr redirigido, el proceso ya informó del error en el fichero.
+			cmdErr := commandError(last, lastErr, stderrs[len(cmds)-1].String())
+			cmdErr.Reported = redirs[len(cmds)-1].stderr != nil
+			return cmdErr
+		}
+		if stdout != nil || redirs[len(cmds)-1].stdout != nil {
+			return NULL
+		}
+		return outputToObject(out.Bytes())
+	}
+	if foreground {
+		stopped := waitStopped(last.Process.Pid)
+		reclaimTerminal()
+		if stopped {
+			return suspendPipeline(pipeCtx, cmds, func(job *Job) Object {
+				defer closeRedirects(redirs)
+				return finish(job)
+			})
+		}
+	}
+	return finish(job)
+}
+
+// outputToObject convierte la salida de un comando en un objeto: Json si es
+// JSON válido y String en otro caso.
+func outputToObject(output []byte) Object {
 	var jsonData interface{}
 	if err := json.Unmarshal(output, &jsonData); err == nil {
-		return &Json{Value: jsonData}
+		// Los escalares (ej. `echo 42`) se convierten en objetos nativos de nsh.
+		return nativeToNshObject(jsonData)
 	}
 	return &String{Value: string(output)}
 }
 
+// commandError convierte el fallo de un proceso externo en un *Error con el
+// comando completo, su stderr y su código de salida. Como en sh, un comando que
+// no existe sale con 127, uno sin permiso de ejecución con 126 y uno terminado
+// por una señal con 128 + el número de la señal.
+func commandError(cmd *exec.Cmd, err error, stderr string) *Error {
+	cmdErr := newError("error ejecutando '%s': %v", cmd.Args[0], err)
+	cmdErr.Command = strings.Join(cmd.Args, " ")
+	cmdErr.Stderr = stderr
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		cmdErr.ExitCode = exitErr.ExitCode()
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			cmdErr.ExitCode = 128 + int(status.Signal())
+		}
+	} else if errors.Is(err, exec.ErrNotFound) {
+		cmdErr.ExitCode = 127
+	} else if errors.Is(err, fs.ErrPermission) {
+		cmdErr.ExitCode = 126
+	}
+	return cmdErr
+}
+
+// expandAlias sustituye el nombre del comando por la definición de su alias, con
+// los argumentos de la llamada al final. Cada alias se expande una sola vez, así
+// que `alias ls = ls -F` no entra en un bucle.
+func expandAlias(cmdExpr *parser.CommandExpression, env *Environment) *parser.CommandExpression {
+	expanded := map[string]bool{}
+	for {
+		ident, ok := cmdExpr.Name.(*parser.Identifier)
+		if !ok || expanded[ident.Value] {
+			return cmdExpr
+		}
+		val, _ := env.Get(ident.Value)
+		alias, ok := val.(*Alias)
+		if !ok {
+			return cmdExpr
+		}
+		expanded[ident.Value] = true
+		args := append(append([]parser.Expression{}, alias.Command.Args...), cmdExpr.Args...)
+		redirects := append(append([]*parser.Redirect{}, alias.Command.Redirects...), cmdExpr.Redirects...)
+		cmdExpr = &parser.CommandExpression{Token: alias.Command.Token, Name: alias.Command.Name, Args: args, Redirects: redirects}
+	}
+}
+
+// externalArg convierte un argumento evaluado en texto para un comando externo.
+// Los literales numéricos conservan su forma original (`chmod 0755`, `sleep 1.50`).
+func externalArg(node parser.Expression, arg Object) string {
+	switch node := node.(type) {
+	case *parser.IntegerLiteral:
+		return node.Token.Literal
+	case *parser.FloatLiteral:
+		return node.Token.Literal
+	}
+	return arg.Inspect()
+}
+
 func newError(format string, a ...interface{}) *Error { return &Error{Message: fmt.Sprintf(format, a...)} }
+
+// interrupted devuelve un error si se canceló ctx (con Ctrl-C), para que los
+// bucles largos se detengan. El código de salida es el de sh tras un SIGINT.
+func interrupted(ctx context.Context) *Error {
+	if ctx.Err() == nil {
+		return nil
+	}
+	return &Error{Message: "interrumpido", ExitCode: 130}
+}
 func isError(obj Object) bool {
 	if obj != nil { return obj.Type() == ERROR_OBJ }
 	return false
@@ -0,0 +1,281 @@
+package evaluator
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/soyunomas/nxsh/pkg/parser"
+)
+
+// testEval parsea y evalúa input en una sesión nueva.
+func testEval(t *testing.T, input string) Object {
+	t.Helper()
+	p := parser.NewParser(parser.NewLexer(input))
+	program := p.ParseProgram()
+	if errs := p.Errors(); len(errs) > 0 {
+		t.Fatalf("%q: errores de parsing: %v", input, errs)
+	}
+	return Eval(context.Background(), program, NewSessionEnvironment())
+}
+
+// parseCommand parsea input, que debe ser un solo comando.
+func parseCommand(t *testing.T, input string) *parser.CommandExpression {
+	t.Helper()
+	p := parser.NewParser(parser.NewLexer(input))
+	program := p.ParseProgram()
+	if errs := p.Errors(); len(errs) > 0 {
+		t.Fatalf("%q: errores de parsing: %v", input, errs)
+	}
+	cmd, ok := program.Statements[0].(*parser.ExpressionStatement).Expression.(*parser.CommandExpression)
+	if !ok {
+		t.Fatalf("%q: no es un comando", input)
+	}
+	return cmd
+}
+
+// testEvalOutput evalúa input y compara la representación del resultado con
+// want, sin espacios en blanco para no depender del formato del JSON.
+func testEvalOutput(t *testing.T, input, want string) {
+	t.Helper()
+	result := testEval(t, input)
+	compact := strings.Join(strings.Fields(result.Inspect()), "")
+	if compact != want {
+		t.Errorf("%q: se obtuvo %s, se esperaba %s", input, compact, want)
+	}
+}
+
+func TestExternalFallback(t *testing.T) {
+	tests := []struct {
+		input string
+		piped bool
+		want  bool
+	}{
+		{`sort`, true, false},
+		{`sort -r --natural`, true, false},
+		{`sort -n`, true, true},
+		{`sort file.txt`, false, true},
+		{`sort`, false, true},
+		{`sort -t: -k2`, true, true},
+		{`sort-by .age`, true, false},
+		{`uniq -c`, true, false},
+		{`uniq -d`, true, true},
+		{`uniq names.txt`, false, true},
+		{`distinct`, true, false},
+		{`ls -l`, false, true},
+	}
+	env := NewSessionEnvironment()
+	for _, tt := range tests {
+		cmd := parseCommand(t, tt.input)
+		if got := isExternalStage(cmd, env, tt.piped); got != tt.want {
+			t.Errorf("%q (piped=%v): externo=%v, se esperaba %v", tt.input, tt.piped, got, tt.want)
+		}
+	}
+}
+
+func TestVariables(t *testing.T) {
+	t.Setenv("NXSH_TEST", "valor")
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`$NXSH_TEST`, `valor`},
+		{`let NXSH_TEST = 1; $NXSH_TEST`, `1`},
+		{`"$NXSH_TEST/dir"`, `valor/dir`},
+		{`let u = [[a, b], [c]]; $u[0][1]`, `b`},
+		{`let u = [a, b, c]; $u[-2:]`, `["b","c"]`},
+		{`let u = [a, b]; "x $u[1] y"`, `xby`},
+		{`let f = "file"; "$f.txt"`, `file.txt`},
+	}
+	for _, tt := range tests {
+		testEvalOutput(t, tt.input, tt.want)
+	}
+	if result := testEval(t, `$NXSH_NO_EXISTE`); !isError(result) {
+		t.Errorf("se esperaba un error para una variable no definida, se obtuvo %s", result.Inspect())
+	}
+}
+
+func TestBlockScoping(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`let total = 0; for i in 1..4 { let total = $total + $i }; $total`, `10`},
+		{`for i in [a, b] { let last = $i }; $last`, `b`},
+		{`if true { let x = 1 }; $x`, `1`},
+		{`try { throw fallo } catch e { let msg = $e.message }; $msg`, `fallo`},
+		{`let n = 0; for i in 1..3 { for j in 1..3 { let n = $n + 1 } }; $n`, `9`},
+		{`let i = 7; for i in 1..3 { }; $i`, `7`},
+		{`echo 1..3`, `1..3`},
+	}
+	for _, tt := range tests {
+		testEvalOutput(t, tt.input, tt.want)
+	}
+	for _, input := range []string{`for i in 1..2 { }; $i`, `try { throw x } catch e { }; $e`} {
+		if result := testEval(t, input); !isError(result) {
+			t.Errorf("%q: la variable del bloque no debería verse fuera, se obtuvo %s", input, result.Inspect())
+		}
+	}
+}
+
+func TestRedirectBuiltinStderr(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		input string
+		file  string
+		want  string
+	}{
+		{`get .x 2> "$dir/a"`, "a", "get: requiere una entrada de un pipeline\n"},
+		{`where .a > 1 2>> "$dir/a"`, "a", "get: requiere una entrada de un pipeline\nwhere: requiere una entrada de un pipeline\n"},
+		{`get .x > "$dir/c" 2>&1`, "c", "get: requiere una entrada de un pipeline\n"},
+		{`[2, 1] | sort 2> "$dir/d"`, "d", ""},
+	}
+	for _, tt := range tests {
+		testEval(t, `let dir = "`+dir+`"; `+tt.input)
+		data, err := os.ReadFile(filepath.Join(dir, tt.file))
+		if err != nil {
+			t.Fatalf("%q: %v", tt.input, err)
+		}
+		if string(data) != tt.want {
+			t.Errorf("%q: el fichero contiene %q, se esperaba %q", tt.input, data, tt.want)
+		}
+	}
+	if result := testEval(t, `get .x 2> "`+dir+`/e"`); !isError(result) {
+		t.Errorf("el comando debería seguir fallando, se obtuvo %s", result.Inspect())
+	}
+}
+
+func TestArithmetic(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`1 + 2 * 3`, `7`},
+		{`(1 + 2) * -3`, `-9`},
+		{`7 / 2`, `3.5`},
+		{`10 % 3`, `1`},
+		{`1.5 + 1`, `2.5`},
+		{`let n = 4; $n * $n`, `16`},
+		{`(2+3)*2`, `10`},
+		{`let a = 4; let t = ($a+1)*2; $t`, `10`},
+		{`let x = 4; $x*3`, `12`},
+		{`let x = 4; $x-1`, `3`},
+		{`-(2+3)`, `-5`},
+		{`5 -1`, `4`},
+		{`let x = 4; $x -1`, `3`},
+		{`let x = 7; $x%4 + $x/7`, `4`},
+		{`let x = 4; echo ($x*2)`, `8`},
+		{`[1, 2] | each { |x| $x+$x }`, `[2,4]`},
+	}
+	for _, tt := range tests {
+		testEvalOutput(t, tt.input, tt.want)
+	}
+	for _, input := range []string{`1 / 0`, `"a" + 1`} {
+		if result := testEval(t, input); !isError(result) {
+			t.Errorf("%q: se esperaba un error, se obtuvo %s", input, result.Inspect())
+		}
+	}
+}
+
+func TestControlFlow(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`if 2 > 1 { "si" } else { "no" }`, `si`},
+		{`if 1 > 2 { "a" } else if 2 > 1 { "b" } else { "c" }`, `b`},
+		{`let n = 0; for i in 1..10 { if $i > 3 { break }; let n = $i }; $n`, `3`},
+		{`false && echo no; $?`, `1`},
+		{`sh -c 'exit 3' || true; $?`, `0`},
+		{`true && "si"`, `si`},
+		{`false || "si"`, `si`},
+		{`let x = 2; $x > 5; $?`, `1`},
+	}
+	for _, tt := range tests {
+		testEvalOutput(t, tt.input, tt.want)
+	}
+}
+
+func TestFunctions(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`def doble(x) { return $x * 2 }; doble 4`, `8`},
+		{`def suma(a, b) { $a + $b }; suma 2 3`, `5`},
+		{`def total() { $in | sum }; [1, 2] | total`, `3`},
+	}
+	for _, tt := range tests {
+		testEvalOutput(t, tt.input, tt.want)
+	}
+	result := testEval(t, `def f(x) { $x }; f 1 2`)
+	if err, ok := result.(*Error); !ok || !strings.Contains(err.Message, "se esperaban 1 argumentos") {
+		t.Errorf("se esperaba un error de aridad, se obtuvo %s", result.Inspect())
+	}
+}
+
+func TestTryCatch(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`try { throw fallo } catch e { $e.message }`, `fallo`},
+		{`try { sh -c 'exit 3' } catch e { $e.exit_code }`, `3`},
+		{`try { error x } catch e { $e.command }`, `error`},
+		{`try { 1 } catch e { 2 }`, `1`},
+		{`try { throw x }`, `null`},
+		{`sh -c 'exit 2' || "otro"`, `otro`},
+	}
+	for _, tt := range tests {
+		testEvalOutput(t, tt.input, tt.want)
+	}
+	if result := testEval(t, `try { exit 4 } catch e { 0 }`); ExitStatus(result) != 4 {
+		t.Errorf("exit dentro de try: se obtuvo %s", result.Inspect())
+	}
+}
+
+const pathRecord = `let u = (echo '{"name": "ann", "tags": ["a", "b", "c"], "loc": {"city": "x"}, "a b": 1}')`
+
+func TestGet(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{pathRecord + `; $u | get .name`, `ann`},
+		{pathRecord + `; $u | get .tags[1]`, `b`},
+		{pathRecord + `; $u | get .tags[-1]`, `c`},
+		{pathRecord + `; $u | get .tags[1:]`, `["b","c"]`},
+		{pathRecord + `; $u | get .tags[*]`, `["a","b","c"]`},
+		{pathRecord + `; $u | get ."a b"`, `1`},
+		{pathRecord + `; $u | get .loc.city`, `x`},
+		{pathRecord + `; $u | get .`, `{"ab":1,"loc":{"city":"x"},"name":"ann","tags":["a","b","c"]}`},
+		{pathRecord + `; [$u, $u] | get .loc.city`, `["x","x"]`},
+	}
+	for _, tt := range tests {
+		testEvalOutput(t, tt.input, tt.want)
+	}
+	if result := testEval(t, `get .name`); !isError(result) {
+		t.Errorf("get sin entrada: se esperaba un error, se obtuvo %s", result.Inspect())
+	}
+}
+
+func TestSelect(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{pathRecord + `; $u | select .name .loc.city`, `{"city":"x","name":"ann"}`},
+		{pathRecord + `; $u | select n: .name`, `{"n":"ann"}`},
+		{pathRecord + `; $u | select --nested .loc.city .name`, `{"loc":{"city":"x"},"name":"ann"}`},
+		{pathRecord + `; $u | select l: { $it.tags | count }`, `{"l":3}`},
+	}
+	for _, tt := range tests {
+		testEvalOutput(t, tt.input, tt.want)
+	}
+	result := testEval(t, pathRecord+`; $u | select .name .name`)
+	if err, ok := result.(*Error); !ok || !strings.Contains(err.Message, "producen la misma clave 'name'") {
+		t.Errorf("se esperaba un error de clave repetida, se obtuvo %s", result.Inspect())
+	}
+}
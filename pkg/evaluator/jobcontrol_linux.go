@@ -0,0 +1,116 @@
+//go:build linux
+
+package evaluator
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// terminal guarda la terminal de la sesión cuando el control de trabajos está
+// activo (fd -1 si no lo está) y el grupo de procesos de nxsh.
+var terminal = struct{ fd, pgid int }{fd: -1}
+
+const (
+	sigBlock   = 0 // SIG_BLOCK
+	sigSetmask = 2 // SIG_SETMASK
+	pPID       = 1 // P_PID
+	cldStopped = 5 // CLD_STOPPED
+)
+
+// EnableJobControl activa el control de trabajos sobre la terminal tty: cada
+// pipeline en primer plano se ejecuta en su propio grupo de procesos, que
+// recibe la terminal mientras dura, de modo que Ctrl-C, Ctrl-Z y Ctrl-\ le
+// llegan a él y no a nxsh. Devuelve false si nxsh no está en primer plano en tty.
+func EnableJobControl(tty *os.File) bool {
+	fd := int(tty.Fd())
+	pgid := syscall.Getpgrp()
+	if fg, err := tcgetpgrp(fd); err != nil || fg != pgid {
+		return false
+	}
+	terminal.fd, terminal.pgid = fd, pgid
+	return true
+}
+
+func jobControlEnabled() bool { return terminal.fd >= 0 }
+
+// setProcessGroup prepara cmd para arrancar en el grupo de procesos pgid (uno
+// nuevo si es 0). En primer plano, el grupo recibe además la terminal. Sin
+// control de trabajos solo los trabajos en segundo plano tienen su propio
+// grupo, para que el Ctrl-C de la terminal no les llegue.
+func setProcessGroup(cmd *exec.Cmd, pgid int, background bool) {
+	if !background && !jobControlEnabled() {
+		return
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid:    true,
+		Pgid:       pgid,
+		Foreground: !background,
+		Ctty:       terminal.fd,
+	}
+}
+
+// giveTerminal pone el grupo de procesos pgid en primer plano.
+func giveTerminal(pgid int) {
+	if jobControlEnabled() {
+		tcsetpgrp(terminal.fd, pgid)
+	}
+}
+
+// reclaimTerminal devuelve la terminal a nxsh cuando termina (o se detiene) el
+// grupo de procesos en primer plano.
+func reclaimTerminal() {
+	giveTerminal(terminal.pgid)
+}
+
+// waitStopped espera a que el proceso pid termine o se detenga (con Ctrl-Z), y
+// devuelve true si se detuvo. No recoge el proceso: de eso se encarga exec.Cmd.Wait.
+func waitStopped(pid int) bool {
+	var info [128]byte // siginfo_t
+	for {
+		_, _, errno := syscall.Syscall6(syscall.SYS_WAITID, pPID, uintptr(pid), uintptr(unsafe.Pointer(&info)),
+			syscall.WEXITED|syscall.WSTOPPED|syscall.WNOWAIT, 0, 0)
+		if errno == syscall.EINTR {
+			continue
+		}
+		if errno != 0 {
+			// ECHILD: otro Wait ya recogió el proceso, así que terminó.
+			return false
+		}
+		// si_code va tras si_signo y si_errno.
+		return *(*int32)(unsafe.Pointer(&info[8])) == cldStopped
+	}
+}
+
+func tcgetpgrp(fd int) (int, error) {
+	var pgid int32
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.TIOCGPGRP, uintptr(unsafe.Pointer(&pgid)))
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(pgid), nil
+}
+
+// tcsetpgrp pone el grupo pgid en primer plano en la terminal fd. Cuando nxsh
+// recupera la terminal no está en primer plano, y el kernel le enviaría
+// SIGTTOU; como hacen las shells, la bloqueamos durante la llamada (sin
+// ignorarla, porque los procesos hijos heredarían la señal ignorada).
+func tcsetpgrp(fd, pgid int) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	block := uint64(1) << (uint(syscall.SIGTTOU) - 1)
+	var old uint64
+	syscall.RawSyscall6(syscall.SYS_RT_SIGPROCMASK, sigBlock, uintptr(unsafe.Pointer(&block)), uintptr(unsafe.Pointer(&old)), 8, 0, 0)
+	defer syscall.RawSyscall6(syscall.SYS_RT_SIGPROCMASK, sigSetmask, uintptr(unsafe.Pointer(&old)), 0, 8, 0, 0)
+
+	id := int32(pgid)
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.TIOCSPGRP, uintptr(unsafe.Pointer(&id)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
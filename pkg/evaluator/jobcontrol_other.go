@@ -0,0 +1,25 @@
+//go:build !linux
+
+package evaluator
+
+import (
+	"os"
+	"os/exec"
+)
+
+// El control de trabajos (grupos de procesos y terminal) solo está disponible
+// en Linux. En el resto de sistemas los procesos comparten el grupo de nxsh,
+// que atiende Ctrl-C cancelando el contexto de la evaluación.
+
+// EnableJobControl no hace nada fuera de Linux y devuelve false.
+func EnableJobControl(tty *os.File) bool { return false }
+
+func jobControlEnabled() bool { return false }
+
+func setProcessGroup(cmd *exec.Cmd, pgid int, background bool) {}
+
+func giveTerminal(pgid int) {}
+
+func reclaimTerminal() {}
+
+func waitStopped(pid int) bool { return false }
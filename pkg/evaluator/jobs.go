@@ -0,0 +1,562 @@
+package evaluator
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/soyunomas/nxsh/pkg/parser"
+)
+
+// Job es un statement lanzado en segundo plano con `&`. Se evalúa en su propia
+// goroutine y su resultado se guarda hasta que se recoge con `wait` o `fg`.
+type Job struct {
+	ID      int
+	Command string
+
+	cancel  context.CancelFunc
+	done    chan struct{}
+	started chan struct{} // se cierra al empezar su primer comando (ver markStarted)
+	once    sync.Once
+
+	mu        sync.Mutex
+	processes []*os.Process // procesos externos en ejecución
+	pgid      int           // grupo de procesos del pipeline en ejecución
+	stopped   bool
+	reported  bool // ya se avisó en la terminal de que terminó
+	result    Object
+}
+
+// jobs es la tabla de trabajos en segundo plano de la sesión.
+var jobs struct {
+	sync.Mutex
+	list []*Job
+}
+
+type jobKey struct{}
+
+// jobFromContext devuelve el trabajo al que pertenece la evaluación de ctx, o
+// nil si se evalúa en primer plano.
+func jobFromContext(ctx context.Context) *Job {
+	job, _ := ctx.Value(jobKey{}).(*Job)
+	return job
+}
+
+// inBackground indica si la evaluación de ctx ocurre fuera del primer plano:
+// en un trabajo o en un bloque de par-each. Sus procesos no leen de la
+// terminal ni la reciben, y no cambian el estado de la sesión.
+func inBackground(ctx context.Context) bool {
+	return jobFromContext(ctx) != nil || ctx.Value(parallelKey{}) != nil
+}
+
+// startJob lanza stmt como un trabajo en segundo plano y devuelve `[n] comando`.
+// El trabajo no depende del contexto de quien lo lanza: sigue vivo cuando el
+// statement termina, hasta que acaba o se le envía una señal con `kill`.
+func startJob(ctx context.Context, stmt *parser.ExpressionStatement, env *Environment) Object {
+	jobCtx, cancel := context.WithCancel(context.Background())
+	job := newJob(stmt.Expression.String(), cancel)
+	jobCtx = context.WithValue(jobCtx, jobKey{}, job)
+
+	// Un entorno propio evita que los `let` de los bloques del trabajo pisen
+	// las variables de la sesión mientras esta sigue ejecutando otros comandos.
+	jobEnv := NewEnclosedEnvironment(env)
+	go job.run(func() Object { return evalExpression(jobCtx, stmt.Expression, jobEnv, nil) })
+	// Hasta que arranca su primer comando, `jobs` mostraría el trabajo sin
+	// procesos y `kill %n` no tendría a quién enviar la señal.
+	select {
+	case <-job.started:
+	case <-job.done:
+	}
+	return &String{Value: fmt.Sprintf("[%d] %s", job.ID, job.Command)}
+}
+
+// suspendPipeline convierte un pipeline en primer plano que se detuvo con
+// Ctrl-Z en un trabajo detenido, que se reanuda con `fg` o `bg`. finish espera
+// a sus procesos y devuelve el resultado del pipeline.
+func suspendPipeline(pipeCtx *pipelineContext, cmds []*exec.Cmd, finish func(job *Job) Object) Object {
+	var parts []string
+	for _, cmd := range cmds {
+		parts = append(parts, strings.Join(cmd.Args, " "))
+	}
+	job := newJob(strings.Join(parts, " | "), pipeCtx.detach())
+	job.stopped = true
+	for _, cmd := range cmds {
+		job.track(cmd.Process, cmds[0].Process.Pid)
+	}
+	go job.run(func() Object { return finish(job) })
+	return stoppedError(job)
+}
+
+// stoppedError es el resultado de un pipeline detenido: no terminó, así que
+// cuenta como un fallo, con el código de salida de sh (128 + SIGTSTP).
+func stoppedError(job *Job) *Error {
+	return &Error{
+		Message:  fmt.Sprintf("[%d] detenido: %s", job.ID, job.Command),
+		Command:  job.Command,
+		ExitCode: 128 + int(lookupSignal("TSTP")),
+	}
+}
+
+// newJob añade un trabajo a la tabla con el primer número libre tras los
+// existentes. cancel detiene su evaluación.
+func newJob(command string, cancel context.CancelFunc) *Job {
+	job := &Job{Command: command, cancel: cancel, done: make(chan struct{}), started: make(chan struct{})}
+	jobs.Lock()
+	defer jobs.Unlock()
+	job.ID = 1
+	for _, other := range jobs.list {
+		if other.ID >= job.ID {
+			job.ID = other.ID + 1
+		}
+	}
+	jobs.list = append(jobs.list, job)
+	return job
+}
+
+// run evalúa el trabajo con eval y guarda su resultado.
+func (j *Job) run(eval func() Object) {
+	result := eval()
+	j.mu.Lock()
+	j.result = result
+	j.mu.Unlock()
+	j.cancel()
+	j.markStarted()
+	close(j.done)
+}
+
+// markStarted indica que el trabajo ya empezó su primer comando: un builtin o
+// un pipeline externo con todos sus procesos registrados. No hace nada fuera
+// de un trabajo (job nil).
+func (j *Job) markStarted() {
+	if j == nil {
+		return
+	}
+	j.once.Do(func() { close(j.started) })
+}
+
+// track registra un proceso externo del trabajo, del grupo de procesos pgid,
+// para poder enviarle señales. No hace nada fuera de un trabajo (job nil).
+func (j *Job) track(p *os.Process, pgid int) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	j.processes = append(j.processes, p)
+	j.pgid = pgid
+	j.mu.Unlock()
+}
+
+// group devuelve el grupo de procesos del pipeline en ejecución del trabajo y
+// el pid de su último proceso, o ceros si no tiene procesos.
+func (j *Job) group() (pgid, pid int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if len(j.processes) == 0 {
+		return 0, 0
+	}
+	return j.pgid, j.processes[len(j.processes)-1].Pid
+}
+
+// untrack olvida un proceso del trabajo cuando termina.
+func (j *Job) untrack(p *os.Process) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for i, tracked := range j.processes {
+		if tracked == p {
+			j.processes = append(j.processes[:i], j.processes[i+1:]...)
+			return
+		}
+	}
+}
+
+// finished indica si el trabajo ya terminó.
+func (j *Job) finished() bool {
+	select {
+	case <-j.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// setStopped marca el trabajo como detenido o en ejecución.
+func (j *Job) setStopped(stopped bool) {
+	j.mu.Lock()
+	j.stopped = stopped
+	j.mu.Unlock()
+}
+
+// status devuelve el estado del trabajo tal como lo muestra `jobs`.
+func (j *Job) status() string {
+	if j.finished() {
+		return "done"
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.stopped {
+		return "stopped"
+	}
+	return "running"
+}
+
+// signal envía sig a todos los procesos del trabajo. Si el trabajo no tiene
+// procesos en ejecución (solo evalúa comandos internos), una señal de
+// terminación cancela su contexto.
+func (j *Job) signal(name string, sig syscall.Signal) error {
+	j.mu.Lock()
+	processes := append([]*os.Process(nil), j.processes...)
+	j.mu.Unlock()
+	switch name {
+	case "STOP", "TSTP":
+		j.setStopped(true)
+	case "CONT":
+		j.setStopped(false)
+	}
+
+	if len(processes) == 0 {
+		// La señal 0 solo comprueba que el trabajo existe.
+		if sig != 0 && name != "STOP" && name != "TSTP" && name != "CONT" {
+			j.cancel()
+		}
+		return nil
+	}
+	for _, p := range processes {
+		if err := p.Signal(sig); err != nil && err != os.ErrProcessDone {
+			return err
+		}
+	}
+	return nil
+}
+
+// wait espera a que el trabajo termine (o a que se cancele ctx), lo quita de la
+// tabla y devuelve su resultado.
+func (j *Job) wait(ctx context.Context) Object {
+	select {
+	case <-j.done:
+	case <-ctx.Done():
+		return newError("wait: interrumpido")
+	}
+	removeJob(j)
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.result
+}
+
+// pipelineContext es el contexto de los procesos de un pipeline externo. Se
+// cancela con el contexto del statement mientras el pipeline está en primer
+// plano; detach lo desliga cuando el pipeline se detiene y pasa a ser un trabajo.
+type pipelineContext struct {
+	context.Context
+	cancel   context.CancelFunc
+	detached chan struct{}
+}
+
+func newPipelineContext(ctx context.Context) *pipelineContext {
+	procCtx, cancel := context.WithCancel(context.Background())
+	p := &pipelineContext{Context: procCtx, cancel: cancel, detached: make(chan struct{})}
+	go func() {
+		select {
+		case <-ctx.Done():
+			cancel()
+		case <-p.detached:
+		case <-procCtx.Done():
+		}
+	}()
+	return p
+}
+
+// detach desliga el contexto del statement y devuelve la función que lo cancela.
+func (p *pipelineContext) detach() context.CancelFunc {
+	close(p.detached)
+	return p.cancel
+}
+
+// release cancela el contexto al terminar el pipeline, salvo que se haya
+// desligado, y devuelve si lo canceló.
+func (p *pipelineContext) release() bool {
+	select {
+	case <-p.detached:
+		return false
+	default:
+		p.cancel()
+		return true
+	}
+}
+
+// removeJob quita un trabajo de la tabla.
+func removeJob(job *Job) {
+	jobs.Lock()
+	defer jobs.Unlock()
+	for i, other := range jobs.list {
+		if other == job {
+			jobs.list = append(jobs.list[:i], jobs.list[i+1:]...)
+			return
+		}
+	}
+}
+
+// listJobs devuelve una copia de la tabla de trabajos, ordenada por número.
+func listJobs() []*Job {
+	jobs.Lock()
+	defer jobs.Unlock()
+	list := append([]*Job(nil), jobs.list...)
+	sort.Slice(list, func(a, b int) bool { return list[a].ID < list[b].ID })
+	return list
+}
+
+// lookupJob busca un trabajo por su especificación `%n`. Sin especificación,
+// devuelve el más reciente.
+func lookupJob(command string, args []Object) (*Job, *Error) {
+	if len(args) > 1 {
+		return nil, newError("uso: %s [%%n]", command)
+	}
+	list := listJobs()
+	if len(args) == 0 {
+		if len(list) == 0 {
+			return nil, newError("%s: no hay trabajos", command)
+		}
+		return list[len(list)-1], nil
+	}
+	spec := args[0].Inspect()
+	id, err := strconv.Atoi(strings.TrimPrefix(spec, "%"))
+	if !strings.HasPrefix(spec, "%") || err != nil {
+		return nil, newError("%s: trabajo inválido '%s' (se esperaba %%n)", command, spec)
+	}
+	for _, job := range list {
+		if job.ID == id {
+			return job, nil
+		}
+	}
+	return nil, newError("%s: no existe el trabajo %%%d", command, id)
+}
+
+// FinishedJobs devuelve un aviso `[n] done comando` por cada trabajo que ha
+// terminado desde la última llamada. El REPL los muestra antes del prompt.
+func FinishedJobs() []string {
+	var notices []string
+	for _, job := range listJobs() {
+		if !job.finished() {
+			continue
+		}
+		job.mu.Lock()
+		if !job.reported {
+			job.reported = true
+			state := "done"
+			if isError(job.result) {
+				state = "failed"
+			}
+			notices = append(notices, fmt.Sprintf("[%d] %s %s", job.ID, state, job.Command))
+		}
+		job.mu.Unlock()
+	}
+	return notices
+}
+
+// builtinJobs implementa 'jobs': devuelve la tabla de trabajos como un array de
+// registros con id, status, pids y command.
+func builtinJobs(_ context.Context, _ Object, args ...Object) Object {
+	if len(args) > 0 {
+		return newError("uso: jobs")
+	}
+	result := []interface{}{}
+	for _, job := range listJobs() {
+		status := job.status()
+		job.mu.Lock()
+		pids := []interface{}{}
+		for _, p := range job.processes {
+			pids = append(pids, float64(p.Pid))
+		}
+		job.mu.Unlock()
+		result = append(result, map[string]interface{}{
+			"id":      float64(job.ID),
+			"status":  status,
+			"pids":    pids,
+			"command": job.Command,
+		})
+	}
+	return &Json{Value: result}
+}
+
+// builtinWait implementa 'wait': `wait %n` espera a un trabajo y devuelve su
+// resultado (o su error); `wait` sin argumentos espera a todos.
+func builtinWait(ctx context.Context, _ Object, args ...Object) Object {
+	if len(args) == 0 {
+		for _, job := range listJobs() {
+			job.wait(ctx)
+			if ctx.Err() != nil {
+				return newError("wait: interrumpido")
+			}
+		}
+		return NULL
+	}
+	job, err := lookupJob("wait", args)
+	if err != nil {
+		return err
+	}
+	return job.wait(ctx)
+}
+
+// builtinFg implementa 'fg': reanuda el trabajo si estaba detenido y espera a
+// que termine, devolviendo su resultado como si se hubiera ejecutado en primer plano.
+// Con control de trabajos, el pipeline del trabajo recibe la terminal mientras
+// dura, así que Ctrl-C le llega a él y Ctrl-Z lo vuelve a detener.
+func builtinFg(ctx context.Context, _ Object, args ...Object) Object {
+	job, errObj := lookupJob("fg", args)
+	if errObj != nil {
+		return errObj
+	}
+	pgid, pid := job.group()
+	if !jobControlEnabled() || pgid == 0 {
+		if err := resumeJob(job); err != nil {
+			return newError("fg: %v", err)
+		}
+		return job.wait(ctx)
+	}
+
+	giveTerminal(pgid)
+	err := resumeJob(job)
+	stopped := err == nil && waitStopped(pid)
+	reclaimTerminal()
+	if err != nil {
+		return newError("fg: %v", err)
+	}
+	if stopped {
+		job.setStopped(true)
+		return stoppedError(job)
+	}
+	return job.wait(ctx)
+}
+
+// builtinBg implementa 'bg': reanuda en segundo plano un trabajo detenido.
+func builtinBg(_ context.Context, _ Object, args ...Object) Object {
+	job, errObj := lookupJob("bg", args)
+	if errObj != nil {
+		return errObj
+	}
+	if err := resumeJob(job); err != nil {
+		return newError("bg: %v", err)
+	}
+	return &String{Value: fmt.Sprintf("[%d] %s &", job.ID, job.Command)}
+}
+
+// resumeJob envía SIGCONT a un trabajo detenido.
+func resumeJob(job *Job) error {
+	if job.status() != "stopped" {
+		return nil
+	}
+	sig := lookupSignal("CONT")
+	if sig == 0 {
+		return fmt.Errorf("no se pueden reanudar trabajos en este sistema")
+	}
+	return job.signal("CONT", sig)
+}
+
+// builtinKill implementa 'kill [-SEÑAL] %n|pid...'. La señal puede darse por
+// nombre (-TERM, -SIGTERM) o por número (-9); por defecto es TERM.
+func builtinKill(_ context.Context, _ Object, args ...Object) Object {
+	name := "TERM"
+	if len(args) > 1 && isSignalNumber(args[0]) {
+		name = strconv.FormatInt(-args[0].(*Integer).Value, 10)
+		args = args[1:]
+	} else if len(args) > 0 && strings.HasPrefix(args[0].Inspect(), "-") {
+		name = strings.TrimPrefix(strings.ToUpper(args[0].Inspect()[1:]), "SIG")
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return newError("uso: kill [-SEÑAL] %%n|pid ...")
+	}
+	sig, err := parseSignal(name)
+	if err != nil {
+		return err
+	}
+	// Con un número de señal, usamos su nombre para saber si detiene o reanuda.
+	for _, s := range signals {
+		if s.sig == sig {
+			name = s.name
+			break
+		}
+	}
+
+	for _, arg := range args {
+		target := arg.Inspect()
+		if strings.HasPrefix(target, "%") {
+			job, errObj := lookupJob("kill", []Object{arg})
+			if errObj != nil {
+				return errObj
+			}
+			if err := job.signal(name, sig); err != nil {
+				return newError("kill: %s: %v", target, err)
+			}
+			continue
+		}
+		pid, convErr := strconv.Atoi(target)
+		if convErr != nil {
+			return newError("kill: argumento inválido '%s' (se esperaba %%n o un pid)", target)
+		}
+		process, findErr := os.FindProcess(pid)
+		if findErr == nil {
+			findErr = process.Signal(sig)
+		}
+		if findErr != nil {
+			return newError("kill: %d: %v", pid, findErr)
+		}
+	}
+	return NULL
+}
+
+// isSignalNumber indica si el primer argumento de `kill` es un número de
+// señal: `-9` y `-0` llegan como enteros (el segundo, como un 0 sin signo),
+// así que un entero no positivo seguido de más argumentos es una señal.
+func isSignalNumber(arg Object) bool {
+	n, ok := arg.(*Integer)
+	return ok && n.Value <= 0
+}
+
+// killIsExternal indica si `kill` ejecuta el programa del sistema: cuando
+// ningún argumento es un trabajo (`%n`), que solo conoce el builtin, y el
+// sistema tiene un `kill` (en Windows no lo hay).
+func killIsExternal(args []parser.Expression, _ bool) bool {
+	for _, arg := range args {
+		if strings.HasPrefix(arg.String(), "%") {
+			return false
+		}
+	}
+	_, err := exec.LookPath("kill")
+	return err == nil
+}
+
+// namedSignal asocia una señal con su nombre sin el prefijo SIG.
+type namedSignal struct {
+	name string
+	sig  syscall.Signal
+}
+
+// lookupSignal devuelve la señal con ese nombre, o 0 si el sistema no la tiene.
+func lookupSignal(name string) syscall.Signal {
+	for _, s := range signals {
+		if s.name == name {
+			return s.sig
+		}
+	}
+	return 0
+}
+
+// parseSignal convierte el nombre o el número de una señal en syscall.Signal.
+func parseSignal(name string) (syscall.Signal, *Error) {
+	if n, err := strconv.Atoi(name); err == nil {
+		return syscall.Signal(n), nil
+	}
+	if sig := lookupSignal(name); sig != 0 {
+		return sig, nil
+	}
+	return 0, newError("kill: señal desconocida '%s'", name)
+}
@@ -0,0 +1,83 @@
+package evaluator
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestKillArgs(t *testing.T) {
+	tests := []struct {
+		args []Object
+		want string
+	}{
+		{[]Object{&Integer{Value: 0}, &String{Value: "%7"}}, "kill: no existe el trabajo %7"},
+		{[]Object{&Integer{Value: -9}, &String{Value: "%7"}}, "kill: no existe el trabajo %7"},
+		{[]Object{&String{Value: "-SIGKILL"}, &String{Value: "%7"}}, "kill: no existe el trabajo %7"},
+		{[]Object{&String{Value: "-FOO"}, &String{Value: "%7"}}, "kill: señal desconocida 'FOO'"},
+		{[]Object{&String{Value: "-TERM"}}, "uso: kill"},
+		{[]Object{&String{Value: "abc"}}, "kill: argumento inválido 'abc'"},
+	}
+	for _, tt := range tests {
+		result := builtinKill(context.Background(), nil, tt.args...)
+		err, ok := result.(*Error)
+		if !ok || !strings.Contains(err.Message, tt.want) {
+			t.Errorf("kill %s: se obtuvo %s, se esperaba un error con %q", commandLine("", tt.args), result.Inspect(), tt.want)
+		}
+	}
+}
+
+func TestJobPids(t *testing.T) {
+	if _, err := exec.LookPath("sleep"); err != nil {
+		t.Skip("el sistema no tiene un programa sleep")
+	}
+	testEval(t, `sleep 5 &`)
+	list := listJobs()
+	job := list[len(list)-1]
+	defer job.wait(context.Background())
+	defer job.signal("KILL", syscall.SIGKILL)
+	// Los pids ya están en la tabla cuando `&` devuelve el control.
+	jobsOut := builtinJobs(context.Background(), nil).(*Json).Value.([]interface{})
+	entry := jobsOut[len(jobsOut)-1].(map[string]interface{})
+	if pids := entry["pids"].([]interface{}); len(pids) != 1 {
+		t.Errorf("se obtuvieron los pids %v, se esperaba uno", pids)
+	}
+}
+
+func TestKillExternal(t *testing.T) {
+	if _, err := exec.LookPath("kill"); err != nil {
+		t.Skip("el sistema no tiene un programa kill")
+	}
+	env := NewSessionEnvironment()
+	tests := []struct {
+		input string
+		want  bool
+	}{
+		{`kill -0 1`, true},
+		{`kill -TERM 123 456`, true},
+		{`kill %1`, false},
+		{`kill -9 %2`, false},
+	}
+	for _, tt := range tests {
+		if got := isExternalStage(parseCommand(t, tt.input), env, false); got != tt.want {
+			t.Errorf("%q: externo=%v, se esperaba %v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestParseSignal(t *testing.T) {
+	for _, s := range signals {
+		sig, err := parseSignal(s.name)
+		if err != nil || sig != s.sig {
+			t.Errorf("%s: se obtuvo %v (%v), se esperaba %v", s.name, sig, err, s.sig)
+		}
+	}
+	if sig, err := parseSignal("0"); err != nil || sig != 0 {
+		t.Errorf("0: se obtuvo %v (%v)", sig, err)
+	}
+	if lookupSignal("NOEXISTE") != 0 {
+		t.Errorf("se esperaba 0 para una señal desconocida")
+	}
+}
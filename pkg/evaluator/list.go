@@ -0,0 +1,293 @@
+package evaluator
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/soyunomas/nxsh/pkg/parser"
+)
+
+// listInput devuelve los elementos de una entrada que debe ser un array JSON o
+// un texto, en cuyo caso los elementos son sus líneas y lines es true.
+func listInput(name string, input Object) (items []interface{}, lines bool, errObj *Error) {
+	if input == nil {
+		return nil, false, newError("%s: requiere una entrada de un pipeline", name)
+	}
+	if text, ok := input.(*String); ok {
+		text := strings.TrimRight(text.Value, "\n")
+		if text == "" {
+			return []interface{}{}, true, nil
+		}
+		for _, line := range strings.Split(text, "\n") {
+			items = append(items, line)
+		}
+		return items, true, nil
+	}
+	items, errObj = inputArray(name, input)
+	return items, false, errObj
+}
+
+// listResult construye el resultado de un comando de listas: un array JSON o,
+// si la entrada era texto, sus líneas unidas de nuevo.
+func listResult(items []interface{}, lines bool) Object {
+	if !lines {
+		if items == nil {
+			items = []interface{}{}
+		}
+		return &Json{Value: items}
+	}
+	text := make([]string, len(items))
+	for i, item := range items {
+		text[i] = item.(string)
+	}
+	return &String{Value: strings.Join(text, "\n")}
+}
+
+// countArg lee el número opcional de elementos de `first`, `last`, `skip` y
+// `take`. Sin argumento, devuelve -1.
+func countArg(name string, args []Object) (int, *Error) {
+	if len(args) == 0 {
+		return -1, nil
+	}
+	if len(args) > 1 {
+		return 0, newError("uso: %s [n]", name)
+	}
+	n, ok := args[0].(*Integer)
+	if !ok || n.Value < 0 {
+		return 0, newError("%s: se esperaba un número no negativo, se obtuvo %s", name, args[0].Inspect())
+	}
+	return int(n.Value), nil
+}
+
+// builtinFirst implementa `first [n]`: sin n devuelve el primer elemento (o
+// NULL si no hay ninguno); con n, la lista de los n primeros.
+func builtinFirst(_ context.Context, input Object, args ...Object) Object {
+	items, lines, errObj := listInput("first", input)
+	if errObj != nil {
+		return errObj
+	}
+	n, errObj := countArg("first", args)
+	if errObj != nil {
+		return errObj
+	}
+	if n < 0 {
+		if len(items) == 0 {
+			return NULL
+		}
+		return nativeToNshObject(items[0])
+	}
+	if n > len(items) {
+		n = len(items)
+	}
+	return listResult(items[:n], lines)
+}
+
+// builtinLast implementa `last [n]`: sin n devuelve el último elemento (o NULL
+// si no hay ninguno); con n, la lista de los n últimos.
+func builtinLast(_ context.Context, input Object, args ...Object) Object {
+	items, lines, errObj := listInput("last", input)
+	if errObj != nil {
+		return errObj
+	}
+	n, errObj := countArg("last", args)
+	if errObj != nil {
+		return errObj
+	}
+	if n < 0 {
+		if len(items) == 0 {
+			return NULL
+		}
+		return nativeToNshObject(items[len(items)-1])
+	}
+	if n > len(items) {
+		n = len(items)
+	}
+	return listResult(items[len(items)-n:], lines)
+}
+
+// builtinTake implementa `take n`: la lista de los n primeros elementos.
+func builtinTake(_ context.Context, input Object, args ...Object) Object {
+	items, lines, errObj := listInput("take", input)
+	if errObj != nil {
+		return errObj
+	}
+	n, errObj := countArg("take", args)
+	if errObj != nil {
+		return errObj
+	}
+	if n < 0 {
+		return newError("uso: take <n>")
+	}
+	if n > len(items) {
+		n = len(items)
+	}
+	return listResult(items[:n], lines)
+}
+
+// builtinSkip implementa `skip [n]`: la lista sin los n primeros elementos
+// (sin n, sin el primero).
+func builtinSkip(_ context.Context, input Object, args ...Object) Object {
+	items, lines, errObj := listInput("skip", input)
+	if errObj != nil {
+		return errObj
+	}
+	n, errObj := countArg("skip", args)
+	if errObj != nil {
+		return errObj
+	}
+	if n < 0 {
+		n = 1
+	}
+	if n > len(items) {
+		n = len(items)
+	}
+	return listResult(items[n:], lines)
+}
+
+// builtinNth implementa `nth <i>`: el elemento en la posición i, empezando en
+// 0; los negativos cuentan desde el final. Fuera de rango devuelve NULL.
+func builtinNth(_ context.Context, input Object, args ...Object) Object {
+	items, _, errObj := listInput("nth", input)
+	if errObj != nil {
+		return errObj
+	}
+	if len(args) != 1 {
+		return newError("uso: nth <índice>")
+	}
+	index, ok := args[0].(*Integer)
+	if !ok {
+		return newError("nth: el índice debe ser un número, se obtuvo %s", args[0].Inspect())
+	}
+	i := int(index.Value)
+	if i < 0 {
+		i += len(items)
+	}
+	if i < 0 || i >= len(items) {
+		return NULL
+	}
+	return nativeToNshObject(items[i])
+}
+
+// builtinReverse implementa `reverse`: la lista en orden inverso.
+func builtinReverse(_ context.Context, input Object, args ...Object) Object {
+	items, lines, errObj := listInput("reverse", input)
+	if errObj != nil {
+		return errObj
+	}
+	if len(args) != 0 {
+		return newError("uso: reverse")
+	}
+	reversed := make([]interface{}, len(items))
+	for i, item := range items {
+		reversed[len(items)-1-i] = item
+	}
+	return listResult(reversed, lines)
+}
+
+// uniqBuiltin construye `uniq [--count]` y su alias `distinct`: la lista sin
+// repetidos, conservando la primera aparición de cada valor. Dos valores son
+// iguales si lo son en profundidad, campo a campo.
+func uniqBuiltin(name string) BuiltinFunction {
+	return func(ctx context.Context, input Object, args ...Object) Object {
+		count, args, errObj := parseCountFlag(name, args)
+		if errObj != nil {
+			return errObj
+		}
+		if len(args) != 0 {
+			return newError("uso: %s [--count] (para comparar por un campo, usa uniq-by)", name)
+		}
+		items, lines, errObj := listInput(name, input)
+		if errObj != nil {
+			return errObj
+		}
+		return uniqueBy(ctx, name, items, lines, nil, count)
+	}
+}
+
+// uniqIsExternal indica si `uniq` ejecuta el programa del sistema: sin entrada
+// de un pipeline (`uniq file.txt`) o con opciones que el builtin no tiene
+// (`sort names.txt | uniq -d`).
+func uniqIsExternal(args []parser.Expression, piped bool) bool {
+	return !piped || !onlyFlags(args, "-c", "--count")
+}
+
+// builtinUniqBy implementa `uniq-by [--count] <.campo>`: la lista sin los
+// elementos cuyo campo repite el valor de uno anterior. Los que no tienen el
+// campo cuentan como null.
+func builtinUniqBy(ctx context.Context, input Object, args ...Object) Object {
+	count, args, errObj := parseCountFlag("uniq-by", args)
+	if errObj != nil {
+		return errObj
+	}
+	if len(args) != 1 {
+		return newError("uso: uniq-by [--count] <.campo>")
+	}
+	pathArg, ok := args[0].(*String)
+	if !ok {
+		return newError("uniq-by: el argumento de ruta debe ser una cadena, se obtuvo %s", args[0].Type())
+	}
+	path, err := parsePath(pathArg.Value)
+	if err != nil {
+		return newError("uniq-by: %v", err)
+	}
+	items, errObj := inputArray("uniq-by", input)
+	if errObj != nil {
+		return errObj
+	}
+	return uniqueBy(ctx, "uniq-by", items, false, path, count)
+}
+
+// parseCountFlag separa el flag `--count` (o `-c`) de los argumentos.
+func parseCountFlag(name string, args []Object) (bool, []Object, *Error) {
+	count := false
+	var rest []Object
+	for _, arg := range args {
+		if flag, ok := arg.(*String); ok && strings.HasPrefix(flag.Value, "-") {
+			if flag.Value != "--count" && flag.Value != "-c" {
+				return false, nil, newError("%s: opción desconocida '%s'", name, flag.Value)
+			}
+			count = true
+			continue
+		}
+		rest = append(rest, arg)
+	}
+	return count, rest, nil
+}
+
+// uniqueBy elimina los elementos repetidos según el valor de path (o del
+// elemento entero, si path está vacía). Con count, devuelve en su lugar un
+// registro `{value, count}` por cada valor distinto, en orden de aparición.
+func uniqueBy(ctx context.Context, name string, items []interface{}, lines bool, path []pathSegment, count bool) Object {
+	seen := make(map[string]int)
+	var unique, values []interface{}
+	var counts []int
+	for _, item := range items {
+		if err := interrupted(ctx); err != nil {
+			return err
+		}
+		value, _ := accessField(item, path)
+		// La serialización de JSON ordena las claves de los objetos, así que
+		// dos valores iguales en profundidad producen el mismo texto.
+		key, err := json.Marshal(value)
+		if err != nil {
+			return newError("%s: %v", name, err)
+		}
+		if i, dup := seen[string(key)]; dup {
+			counts[i]++
+			continue
+		}
+		seen[string(key)] = len(unique)
+		unique = append(unique, item)
+		values = append(values, value)
+		counts = append(counts, 1)
+	}
+	if !count {
+		return listResult(unique, lines)
+	}
+	results := make([]interface{}, len(values))
+	for i, value := range values {
+		results[i] = map[string]interface{}{"value": value, "count": float64(counts[i])}
+	}
+	return &Json{Value: results}
+}
@@ -0,0 +1,44 @@
+package evaluator
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestListBuiltins(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`[1, 2, 3] | first`, `1`},
+		{`[1, 2, 3] | first 2`, `[1,2]`},
+		{`[1, 2, 3] | last 2`, `[2,3]`},
+		{`[1, 2, 3] | skip 1`, `[2,3]`},
+		{`[1, 2, 3] | nth -1`, `3`},
+		{`[1, 2, 3] | reverse`, `[3,2,1]`},
+		{`[] | take 2`, `[]`},
+		{`[b, a, b] | uniq`, `["b","a"]`},
+		{`[b, a, b] | distinct --count`, `[{"count":2,"value":"b"},{"count":1,"value":"a"}]`},
+	}
+	for _, tt := range tests {
+		testEvalOutput(t, tt.input, tt.want)
+	}
+}
+
+func TestUniqErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`[1] | distinct -x`, "distinct: opción desconocida '-x'"},
+		{`[1] | uniq-by -x .a`, "uniq-by: opción desconocida '-x'"},
+		{`"texto" | uniq-by .a`, "uniq-by: la entrada debe ser un array JSON"},
+	}
+	for _, tt := range tests {
+		result := testEval(t, tt.input)
+		err, ok := result.(*Error)
+		if !ok || !strings.Contains(err.Message, tt.want) {
+			t.Errorf("%q: se obtuvo %s, se esperaba un error con %q", tt.input, result.Inspect(), tt.want)
+		}
+	}
+}
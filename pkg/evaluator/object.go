@@ -1,8 +1,13 @@
 package evaluator
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/soyunomas/nxsh/pkg/parser"
+	"strconv"
+	"strings"
 )
 
 // ObjectType es el tipo de un objeto en nsh.
@@ -10,10 +15,22 @@ type ObjectType string
 
 const (
 	STRING_OBJ  ObjectType = "STRING"
+	INTEGER_OBJ ObjectType = "INTEGER"
+	FLOAT_OBJ   ObjectType = "FLOAT"
+	BOOLEAN_OBJ ObjectType = "BOOLEAN"
 	JSON_OBJ    ObjectType = "JSON"
 	NULL_OBJ    ObjectType = "NULL"
 	ERROR_OBJ   ObjectType = "ERROR"
 	BUILTIN_OBJ ObjectType = "BUILTIN"
+
+	FUNCTION_OBJ    ObjectType = "FUNCTION"
+	ALIAS_OBJ       ObjectType = "ALIAS"
+	PREDICATE_OBJ   ObjectType = "PREDICATE"
+	AGGREGATION_OBJ ObjectType = "AGGREGATION"
+
+	RETURN_VALUE_OBJ ObjectType = "RETURN_VALUE"
+	BREAK_OBJ        ObjectType = "BREAK"
+	CONTINUE_OBJ     ObjectType = "CONTINUE"
 )
 
 // Object es la interfaz que todo tipo de dato en nsh debe implementar.
@@ -23,16 +40,50 @@ type Object interface {
 }
 
 // BuiltinFunction es el tipo de las funciones internas de nsh.
-type BuiltinFunction func(input Object, args ...Object) Object
+type BuiltinFunction func(ctx context.Context, input Object, args ...Object) Object
 
-// Builtin representa una función interna.
+// Builtin representa una función interna. Los que tienen el nombre de un
+// programa del sistema (ej. `sort`) indican en External cuándo se ejecuta el
+// programa en su lugar, según los argumentos y si reciben la entrada de un pipeline.
 type Builtin struct {
-	Fn BuiltinFunction
+	Fn       BuiltinFunction
+	External func(args []parser.Expression, piped bool) bool
 }
 
 func (b *Builtin) Type() ObjectType { return BUILTIN_OBJ }
 func (b *Builtin) Inspect() string  { return "builtin function" }
 
+// Function representa una función definida por el usuario con `def`, o un
+// bloque usado como valor (`{ |x| ... }`), que no tiene nombre.
+// Env es el entorno donde se definió, sobre el que se anida el de cada llamada.
+type Function struct {
+	Name       string
+	Parameters []*parser.Identifier
+	Body       *parser.BlockStatement
+	Env        *Environment
+}
+
+func (f *Function) Type() ObjectType { return FUNCTION_OBJ }
+func (f *Function) Inspect() string {
+	if f.Name == "" {
+		return (&parser.ClosureLiteral{Parameters: f.Parameters, Body: f.Body}).String()
+	}
+	var params []string
+	for _, p := range f.Parameters {
+		params = append(params, p.String())
+	}
+	return "def " + f.Name + "(" + strings.Join(params, ", ") + ") " + f.Body.String()
+}
+
+// Alias representa un alias definido con `alias name = comando args...`.
+type Alias struct {
+	Name    string
+	Command *parser.CommandExpression
+}
+
+func (a *Alias) Type() ObjectType { return ALIAS_OBJ }
+func (a *Alias) Inspect() string  { return "alias " + a.Name + " = " + a.Command.String() }
+
 // String representa un valor de cadena.
 type String struct {
 	Value string
@@ -41,6 +92,30 @@ type String struct {
 func (s *String) Type() ObjectType { return STRING_OBJ }
 func (s *String) Inspect() string  { return s.Value }
 
+// Integer representa un número entero.
+type Integer struct {
+	Value int64
+}
+
+func (i *Integer) Type() ObjectType { return INTEGER_OBJ }
+func (i *Integer) Inspect() string  { return strconv.FormatInt(i.Value, 10) }
+
+// Float representa un número decimal.
+type Float struct {
+	Value float64
+}
+
+func (f *Float) Type() ObjectType { return FLOAT_OBJ }
+func (f *Float) Inspect() string  { return strconv.FormatFloat(f.Value, 'f', -1, 64) }
+
+// Boolean representa un valor de verdad.
+type Boolean struct {
+	Value bool
+}
+
+func (b *Boolean) Type() ObjectType { return BOOLEAN_OBJ }
+func (b *Boolean) Inspect() string  { return strconv.FormatBool(b.Value) }
+
 // Json representa datos JSON parseados.
 type Json struct {
 	Value interface{}
@@ -57,6 +132,26 @@ func (j *Json) Inspect() string {
 	return string(b)
 }
 
+// FormatObject devuelve un objeto tal como se muestra en la terminal o se
+// escribe en un fichero: el JSON indentado, y siempre terminado en salto de línea.
+func FormatObject(obj Object) string {
+	if j, ok := obj.(*Json); ok {
+		var out bytes.Buffer
+		encoder := json.NewEncoder(&out)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(j.Value); err != nil {
+			return fmt.Sprintf("Error al formatear JSON: %v\n", err)
+		}
+		return out.String()
+	}
+	text := obj.Inspect()
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	return text
+}
+
 // Null representa la ausencia de valor.
 type Null struct{}
 
@@ -64,9 +159,35 @@ func (n *Null) Type() ObjectType { return NULL_OBJ }
 func (n *Null) Inspect() string  { return "null" }
 
 // Error representa un error que ocurrió durante la evaluación.
+// Si lo produjo un comando, Command, ExitCode y Stderr lo describen.
 type Error struct {
-	Message string
+	Message  string
+	Command  string // comando que falló (vacío si no hubo comando)
+	ExitCode int    // código de salida del proceso (0 si no llegó a terminar)
+	Stderr   string // salida de errores capturada del proceso
+	Exit     bool   // lo produce `exit`: la shell termina con ExitCode
+	Reported bool   // ya se escribió en el destino de `2>`: la shell no lo muestra
 }
 
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
 func (e *Error) Inspect() string  { return "Error: " + e.Message }
+
+// ReturnValue envuelve el valor de un `return` mientras sale de la función.
+type ReturnValue struct {
+	Value Object
+}
+
+func (rv *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
+func (rv *ReturnValue) Inspect() string  { return rv.Value.Inspect() }
+
+// Break indica que un `break` está saliendo del bucle más interno.
+type Break struct{}
+
+func (b *Break) Type() ObjectType { return BREAK_OBJ }
+func (b *Break) Inspect() string  { return "break" }
+
+// Continue indica que un `continue` está saltando a la siguiente iteración.
+type Continue struct{}
+
+func (c *Continue) Type() ObjectType { return CONTINUE_OBJ }
+func (c *Continue) Inspect() string  { return "continue" }
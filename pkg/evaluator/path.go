@@ -0,0 +1,392 @@
+package evaluator
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// segmentKind distingue los pasos de una ruta de campos.
+type segmentKind int
+
+const (
+	keySegment      segmentKind = iota // `.campo`, `."campo"` o `["campo"]`
+	indexSegment                       // `[n]`; los negativos cuentan desde el final
+	sliceSegment                       // `[a:b]`, con a y b opcionales
+	wildcardSegment                    // `[*]` o `.*`
+)
+
+// pathSegment es un paso de una ruta de campos. Para los rangos, start y end
+// son nil cuando se omiten.
+type pathSegment struct {
+	kind       segmentKind
+	key        string
+	index      int
+	start, end *int
+}
+
+// fansOut indica si el paso produce varios valores: un rango o un comodín.
+func (s pathSegment) fansOut() bool {
+	return s.kind == sliceSegment || s.kind == wildcardSegment
+}
+
+// pathFansOut indica si algún paso de la ruta produce varios valores, y por
+// tanto accessField devuelve una lista.
+func pathFansOut(path []pathSegment) bool {
+	for _, seg := range path {
+		if seg.fansOut() {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePath convierte una ruta como `.items[0].name`, `.tags[-1]`,
+// `.users[1:3]`, `.users[*].name`, `.servers.*.ip` o `."clave con espacios"`
+// en sus pasos. La ruta `.` es la ruta vacía: el propio dato.
+func parsePath(path string) ([]pathSegment, error) {
+	var segments []pathSegment
+	rest := strings.TrimPrefix(path, ".")
+	for rest != "" {
+		if rest[0] == '[' {
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("ruta no válida '%s': falta ']'", path)
+			}
+			seg, err := parseBracketSegment(rest[1:end])
+			if err != nil {
+				return nil, fmt.Errorf("ruta no válida '%s': %v", path, err)
+			}
+			segments = append(segments, seg)
+			rest = rest[end+1:]
+			continue
+		}
+		if len(segments) > 0 {
+			if rest[0] != '.' {
+				return nil, fmt.Errorf("ruta no válida '%s': se esperaba '.' o '[' antes de '%s'", path, rest)
+			}
+			rest = rest[1:]
+		}
+		if strings.HasPrefix(rest, `"`) {
+			end := closingQuote(rest)
+			if end < 0 {
+				return nil, fmt.Errorf("ruta no válida '%s': falta la comilla de cierre", path)
+			}
+			key, err := strconv.Unquote(rest[:end+1])
+			if err != nil {
+				return nil, fmt.Errorf("ruta no válida '%s': clave %s mal formada", path, rest[:end+1])
+			}
+			segments = append(segments, pathSegment{kind: keySegment, key: key})
+			rest = rest[end+1:]
+			continue
+		}
+		end := strings.IndexAny(rest, ".[")
+		if end < 0 {
+			end = len(rest)
+		}
+		switch key := rest[:end]; key {
+		case "":
+			return nil, fmt.Errorf("ruta no válida '%s': clave vacía", path)
+		case "*":
+			segments = append(segments, pathSegment{kind: wildcardSegment})
+		default:
+			segments = append(segments, pathSegment{kind: keySegment, key: key})
+		}
+		rest = rest[end:]
+	}
+	return segments, nil
+}
+
+// parseBracketSegment interpreta el contenido de unos corchetes: `*`, un
+// índice, un rango `a:b` o una clave entre comillas.
+func parseBracketSegment(s string) (pathSegment, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case s == "*":
+		return pathSegment{kind: wildcardSegment}, nil
+	case strings.HasPrefix(s, `"`):
+		key, err := strconv.Unquote(s)
+		if err != nil {
+			return pathSegment{}, fmt.Errorf("clave %s mal formada", s)
+		}
+		return pathSegment{kind: keySegment, key: key}, nil
+	case strings.Contains(s, ":"):
+		bounds := strings.SplitN(s, ":", 2)
+		seg := pathSegment{kind: sliceSegment}
+		for i, bound := range bounds {
+			bound = strings.TrimSpace(bound)
+			if bound == "" {
+				continue
+			}
+			n, err := strconv.Atoi(bound)
+			if err != nil {
+				return pathSegment{}, fmt.Errorf("'%s' no es un índice", bound)
+			}
+			if i == 0 {
+				seg.start = &n
+			} else {
+				seg.end = &n
+			}
+		}
+		return seg, nil
+	default:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return pathSegment{}, fmt.Errorf("'%s' no es un índice", s)
+		}
+		return pathSegment{kind: indexSegment, index: n}, nil
+	}
+}
+
+// closingQuote devuelve la posición de la comilla que cierra la cadena que
+// empieza en s[0], o -1 si no la hay.
+func closingQuote(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+// accessField es una función auxiliar para navegar recursivamente en datos JSON
+// parseados. En un rango o un comodín, el resto de la ruta se aplica a cada
+// elemento y el resultado es la lista de los valores encontrados; los comodines
+// anidados se aplanan en una sola lista.
+func accessField(data interface{}, path []pathSegment) (interface{}, bool) {
+	if len(path) == 0 {
+		return data, true
+	}
+	seg, rest := path[0], path[1:]
+	switch seg.kind {
+	case keySegment:
+		obj, ok := data.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		value, found := obj[seg.key]
+		if !found {
+			return nil, false
+		}
+		return accessField(value, rest)
+	case indexSegment:
+		arr, ok := data.([]interface{})
+		if !ok {
+			return nil, false
+		}
+		i := seg.index
+		if i < 0 {
+			i += len(arr)
+		}
+		if i < 0 || i >= len(arr) {
+			return nil, false
+		}
+		return accessField(arr[i], rest)
+	}
+
+	var elements []interface{}
+	switch data := data.(type) {
+	case []interface{}:
+		elements = data
+		if seg.kind == sliceSegment {
+			start, end := sliceBounds(seg, len(data))
+			elements = data[start:end]
+		}
+	case map[string]interface{}:
+		if seg.kind != wildcardSegment {
+			return nil, false
+		}
+		keys := make([]string, 0, len(data))
+		for key := range data {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			elements = append(elements, data[key])
+		}
+	default:
+		return nil, false
+	}
+	flatten := pathFansOut(rest)
+	results := []interface{}{}
+	for _, el := range elements {
+		value, found := accessField(el, rest)
+		if !found {
+			continue
+		}
+		if nested, isList := value.([]interface{}); isList && flatten {
+			results = append(results, nested...)
+		} else {
+			results = append(results, value)
+		}
+	}
+	return results, true
+}
+
+// sliceBounds calcula los límites de un rango sobre una lista de longitud n,
+// como en Python: los negativos cuentan desde el final y los que se salen de
+// la lista se ajustan a ella.
+func sliceBounds(seg pathSegment, n int) (int, int) {
+	bound := func(b *int, def int) int {
+		if b == nil {
+			return def
+		}
+		i := *b
+		if i < 0 {
+			i += n
+		}
+		if i < 0 {
+			return 0
+		}
+		if i > n {
+			return n
+		}
+		return i
+	}
+	start, end := bound(seg.start, 0), bound(seg.end, n)
+	if start > end {
+		start = end
+	}
+	return start, end
+}
+
+// lastKey devuelve la última clave de la ruta, que `select` usa como nombre del
+// campo, o "" si la ruta no tiene claves.
+func lastKey(path []pathSegment) string {
+	for i := len(path) - 1; i >= 0; i-- {
+		if path[i].kind == keySegment {
+			return path[i].key
+		}
+	}
+	return ""
+}
+
+// setField devuelve una copia de data con el valor de path sustituido por
+// value, creando los objetos intermedios que falten. Solo se copian los
+// objetos y arrays del camino, así que data no se modifica.
+func setField(data interface{}, path []pathSegment, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	seg, rest := path[0], path[1:]
+	switch seg.kind {
+	case keySegment:
+		obj, ok := data.(map[string]interface{})
+		if !ok && data != nil {
+			return nil, fmt.Errorf("no se puede asignar la clave '%s' en un valor que no es un objeto", seg.key)
+		}
+		child, err := setField(obj[seg.key], rest, value)
+		if err != nil {
+			return nil, err
+		}
+		updated := make(map[string]interface{}, len(obj)+1)
+		for k, v := range obj {
+			updated[k] = v
+		}
+		updated[seg.key] = child
+		return updated, nil
+	case indexSegment:
+		arr, ok := data.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("no se puede usar el índice %d en un valor que no es un array", seg.index)
+		}
+		i := seg.index
+		if i < 0 {
+			i += len(arr)
+		}
+		if i < 0 || i >= len(arr) {
+			return nil, fmt.Errorf("índice %d fuera de rango", seg.index)
+		}
+		child, err := setField(arr[i], rest, value)
+		if err != nil {
+			return nil, err
+		}
+		updated := append([]interface{}{}, arr...)
+		updated[i] = child
+		return updated, nil
+	default:
+		return nil, fmt.Errorf("no se puede asignar a un rango o un comodín")
+	}
+}
+
+// removeField devuelve una copia de data sin el valor de path, y si lo había.
+// Como en setField, data no se modifica. Un comodín intermedio elimina el
+// resto de la ruta de cada elemento: `.users[*].password`.
+func removeField(data interface{}, path []pathSegment) (interface{}, bool) {
+	if len(path) == 0 {
+		return data, false
+	}
+	seg, rest := path[0], path[1:]
+	switch seg.kind {
+	case keySegment:
+		obj, ok := data.(map[string]interface{})
+		if !ok {
+			return data, false
+		}
+		child, found := obj[seg.key]
+		if !found {
+			return data, false
+		}
+		updated := make(map[string]interface{}, len(obj))
+		for k, v := range obj {
+			updated[k] = v
+		}
+		if len(rest) == 0 {
+			delete(updated, seg.key)
+			return updated, true
+		}
+		if updated[seg.key], found = removeField(child, rest); !found {
+			return data, false
+		}
+		return updated, true
+	case indexSegment:
+		arr, ok := data.([]interface{})
+		if !ok {
+			return data, false
+		}
+		i := seg.index
+		if i < 0 {
+			i += len(arr)
+		}
+		if i < 0 || i >= len(arr) {
+			return data, false
+		}
+		updated := append([]interface{}{}, arr...)
+		if len(rest) == 0 {
+			return append(updated[:i], updated[i+1:]...), true
+		}
+		var found bool
+		if updated[i], found = removeField(arr[i], rest); !found {
+			return data, false
+		}
+		return updated, true
+	case wildcardSegment:
+		if len(rest) == 0 {
+			return data, false
+		}
+		removed := false
+		switch data := data.(type) {
+		case []interface{}:
+			updated := make([]interface{}, len(data))
+			for i, el := range data {
+				var found bool
+				updated[i], found = removeField(el, rest)
+				removed = removed || found
+			}
+			return updated, removed
+		case map[string]interface{}:
+			updated := make(map[string]interface{}, len(data))
+			for k, el := range data {
+				var found bool
+				updated[k], found = removeField(el, rest)
+				removed = removed || found
+			}
+			return updated, removed
+		}
+	}
+	return data, false
+}
@@ -0,0 +1,181 @@
+package evaluator
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/soyunomas/nxsh/pkg/parser"
+)
+
+// Predicate es el predicado de un `where`, preparado para evaluarse sobre cada
+// elemento de la entrada.
+type Predicate struct {
+	root   *predicateNode
+	source string
+}
+
+func (p *Predicate) Type() ObjectType { return PREDICATE_OBJ }
+func (p *Predicate) Inspect() string  { return p.source }
+
+// predicateNode es un nodo de un predicado: una comparación entre el campo
+// path de cada elemento y value (values para `in`, re para `=~` y `!~`), o
+// `and`, `or` y `not` sobre otros nodos.
+type predicateNode struct {
+	op          string
+	left, right *predicateNode
+	path        []pathSegment
+	value       string
+	values      []string
+	re          *regexp.Regexp
+	ignoreCase  bool
+}
+
+// evalPredicateExpression prepara el predicado de un `where`. Los valores con
+// los que se compara se evalúan (y las expresiones regulares se compilan) una
+// sola vez, no una por elemento.
+func evalPredicateExpression(ctx context.Context, node *parser.PredicateExpression, env *Environment) Object {
+	root, err := compilePredicate(ctx, node.Predicate, env, node.IgnoreCase)
+	if err != nil {
+		return err
+	}
+	return &Predicate{root: root, source: node.String()}
+}
+
+func compilePredicate(ctx context.Context, node parser.Expression, env *Environment, ignoreCase bool) (*predicateNode, *Error) {
+	switch node := node.(type) {
+	case *parser.PrefixExpression:
+		right, err := compilePredicate(ctx, node.Right, env, ignoreCase)
+		if err != nil {
+			return nil, err
+		}
+		return &predicateNode{op: node.Operator, right: right}, nil
+	case *parser.InfixExpression:
+		if node.Operator == "and" || node.Operator == "or" {
+			left, err := compilePredicate(ctx, node.Left, env, ignoreCase)
+			if err != nil {
+				return nil, err
+			}
+			right, err := compilePredicate(ctx, node.Right, env, ignoreCase)
+			if err != nil {
+				return nil, err
+			}
+			return &predicateNode{op: node.Operator, left: left, right: right}, nil
+		}
+		pathObj := Eval(ctx, node.Left, env)
+		if err, ok := pathObj.(*Error); ok {
+			return nil, err
+		}
+		pathArg, ok := pathObj.(*String)
+		if !ok {
+			return nil, newError("where: la ruta debe ser una cadena, se obtuvo %s", pathObj.Type())
+		}
+		value := Eval(ctx, node.Right, env)
+		if err, ok := value.(*Error); ok {
+			return nil, err
+		}
+		path, pathErr := parsePath(pathArg.Value)
+		if pathErr != nil {
+			return nil, newError("where: %v", pathErr)
+		}
+		cmp := &predicateNode{
+			op:         node.Operator,
+			path:       path,
+			value:      foldCase(value.Inspect(), ignoreCase),
+			ignoreCase: ignoreCase,
+		}
+		switch cmp.op {
+		case "in":
+			var elements []interface{}
+			if list, ok := value.(*Json); ok {
+				elements, _ = list.Value.([]interface{})
+			}
+			if elements == nil {
+				return nil, newError("where: 'in' requiere una lista, se obtuvo %s", value.Type())
+			}
+			for _, el := range elements {
+				cmp.values = append(cmp.values, foldCase(nativeToNshObject(el).Inspect(), ignoreCase))
+			}
+		case "=~", "!~":
+			pattern := value.Inspect()
+			if ignoreCase {
+				pattern = "(?i)" + pattern
+			}
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, newError("where: expresión regular no válida: %v", err)
+			}
+			cmp.re = re
+		}
+		return cmp, nil
+	default:
+		return nil, newError("where: predicado no válido: %s", node.String())
+	}
+}
+
+// foldCase pasa s a minúsculas si la comparación ignora mayúsculas.
+func foldCase(s string, ignoreCase bool) string {
+	if ignoreCase {
+		return strings.ToLower(s)
+	}
+	return s
+}
+
+// match evalúa el predicado sobre un elemento. Una comparación con un campo
+// que el elemento no tiene es falsa; con una ruta que produce varios valores
+// (`.tags[*]`), es verdadera si lo es para alguno de ellos.
+func (n *predicateNode) match(item interface{}) (bool, error) {
+	switch n.op {
+	case "and":
+		matched, err := n.left.match(item)
+		if err != nil || !matched {
+			return false, err
+		}
+		return n.right.match(item)
+	case "or":
+		matched, err := n.left.match(item)
+		if err != nil || matched {
+			return matched, err
+		}
+		return n.right.match(item)
+	case "not":
+		matched, err := n.right.match(item)
+		return !matched, err
+	}
+	value, found := accessField(item, n.path)
+	if !found {
+		return false, nil
+	}
+	if values, isList := value.([]interface{}); isList && pathFansOut(n.path) {
+		for _, v := range values {
+			if matched, err := n.compare(v); err != nil || matched {
+				return matched, err
+			}
+		}
+		return false, nil
+	}
+	return n.compare(value)
+}
+
+// compare compara un valor del elemento según la comparación del nodo.
+func (n *predicateNode) compare(value interface{}) (bool, error) {
+	switch n.op {
+	case "=~":
+		return n.re.MatchString(fmt.Sprintf("%v", value)), nil
+	case "!~":
+		return !n.re.MatchString(fmt.Sprintf("%v", value)), nil
+	}
+	if s, isString := value.(string); isString && n.ignoreCase {
+		value = strings.ToLower(s)
+	}
+	if n.op == "in" {
+		for _, v := range n.values {
+			if matched, err := evaluateCondition(value, "==", v); err != nil || matched {
+				return matched, err
+			}
+		}
+		return false, nil
+	}
+	return evaluateCondition(value, n.op, n.value)
+}
@@ -0,0 +1,20 @@
+package evaluator
+
+import "testing"
+
+func TestWhere(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{nullAges + ` | where .age > 30 | get .n`, `["b"]`},
+		{nullAges + ` | where .age <= 50 | get .n`, `["b"]`},
+		{nullAges + ` | where not .age < 30 | get .n`, `["a","b","c"]`},
+		{nullAges + ` | where .age != 40 | get .n`, `["a"]`},
+		{nullAges + ` | where .n in [a, c] | get .n`, `["a","c"]`},
+		{nullAges + ` | where -i .n =~ '^[AB]$' | get .n`, `["a","b"]`},
+	}
+	for _, tt := range tests {
+		testEvalOutput(t, tt.input, tt.want)
+	}
+}
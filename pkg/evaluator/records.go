@@ -0,0 +1,211 @@
+package evaluator
+
+import (
+	"context"
+	"strings"
+)
+
+// Los comandos de este fichero modifican campos de registros: reciben un
+// objeto JSON o un array de objetos, y devuelven copias con los cambios, sin
+// tocar la entrada (que puede estar guardada en una variable).
+
+// mapRecords aplica fn a la entrada, si es un objeto, o a cada objeto de un
+// array. Los elementos que no son objetos se dejan tal cual.
+func mapRecords(ctx context.Context, name string, input Object, fn func(record map[string]interface{}) (interface{}, *Error)) Object {
+	if input == nil {
+		return newError("%s: requiere una entrada de un pipeline", name)
+	}
+	jsonInput, ok := input.(*Json)
+	if !ok {
+		return newError("%s: la entrada debe ser de tipo JSON, se obtuvo %s", name, input.Type())
+	}
+	switch data := jsonInput.Value.(type) {
+	case map[string]interface{}:
+		result, err := fn(data)
+		if err != nil {
+			return err
+		}
+		return &Json{Value: result}
+	case []interface{}:
+		results := make([]interface{}, len(data))
+		for i, item := range data {
+			if err := interrupted(ctx); err != nil {
+				return err
+			}
+			record, ok := item.(map[string]interface{})
+			if !ok {
+				results[i] = item
+				continue
+			}
+			result, err := fn(record)
+			if err != nil {
+				return err
+			}
+			results[i] = result
+		}
+		return &Json{Value: results}
+	default:
+		return newError("%s: solo puede operar sobre objetos o arrays de objetos JSON", name)
+	}
+}
+
+// recordPath lee el argumento de ruta de un comando de registros.
+func recordPath(name string, arg Object) ([]pathSegment, *Error) {
+	pathArg, ok := arg.(*String)
+	if !ok {
+		return nil, newError("%s: el argumento de ruta debe ser una cadena, se obtuvo %s", name, arg.Type())
+	}
+	path, err := parsePath(pathArg.Value)
+	if err != nil {
+		return nil, newError("%s: %v", name, err)
+	}
+	if len(path) == 0 {
+		return nil, newError("%s: la ruta no puede estar vacía", name)
+	}
+	return path, nil
+}
+
+// recordValue calcula el valor que un comando asigna a un campo: el propio
+// argumento o, si es un bloque, su resultado al llamarlo con args.
+func recordValue(ctx context.Context, value Object, args ...Object) (interface{}, *Error) {
+	if fn, ok := value.(*Function); ok {
+		value = callClosure(ctx, fn, args...)
+		if err, isErr := value.(*Error); isErr {
+			return nil, err
+		}
+	}
+	return objectToNative(value), nil
+}
+
+// assignField asigna value al campo path de record.
+func assignField(name string, record map[string]interface{}, path []pathSegment, value interface{}) (interface{}, *Error) {
+	result, err := setField(record, path, value)
+	if err != nil {
+		return nil, newError("%s: %v", name, err)
+	}
+	return result, nil
+}
+
+// builtinUpdate implementa `update <.campo> <valor|bloque>`: cambia el valor
+// de un campo que existe. El bloque recibe el valor actual como `$it` y el
+// registro como segundo parámetro: `update .age { $it + 1 }`. Un campo null se
+// trata como uno que falta y el bloque no se llama (no hay valor que cambiar).
+func builtinUpdate(ctx context.Context, input Object, args ...Object) Object {
+	if len(args) != 2 {
+		return newError("uso: update <.campo> <valor|{ bloque }>")
+	}
+	path, errObj := recordPath("update", args[0])
+	if errObj != nil {
+		return errObj
+	}
+	return mapRecords(ctx, "update", input, func(record map[string]interface{}) (interface{}, *Error) {
+		current, found := accessField(record, path)
+		if _, isBlock := args[1].(*Function); !found || (current == nil && isBlock) {
+			return record, nil
+		}
+		value, errObj := recordValue(ctx, args[1], nativeToNshObject(current), &Json{Value: record})
+		if errObj != nil {
+			return nil, errObj
+		}
+		return assignField("update", record, path, value)
+	})
+}
+
+// builtinInsert implementa `insert <.campo> <valor|bloque>`: añade un campo
+// nuevo, que no puede existir ya. El bloque recibe el registro como `$it`.
+func builtinInsert(ctx context.Context, input Object, args ...Object) Object {
+	if len(args) != 2 {
+		return newError("uso: insert <.campo> <valor|{ bloque }>")
+	}
+	path, errObj := recordPath("insert", args[0])
+	if errObj != nil {
+		return errObj
+	}
+	return mapRecords(ctx, "insert", input, func(record map[string]interface{}) (interface{}, *Error) {
+		if _, found := accessField(record, path); found {
+			return nil, newError("insert: el campo '%s' ya existe (usa update para cambiarlo)", args[0].Inspect())
+		}
+		value, errObj := recordValue(ctx, args[1], &Json{Value: record})
+		if errObj != nil {
+			return nil, errObj
+		}
+		return assignField("insert", record, path, value)
+	})
+}
+
+// builtinDefault implementa `default <.campo> <valor|bloque>`: da un valor a
+// un campo que falta o es null. El bloque recibe el registro como `$it`.
+func builtinDefault(ctx context.Context, input Object, args ...Object) Object {
+	if len(args) != 2 {
+		return newError("uso: default <.campo> <valor|{ bloque }>")
+	}
+	path, errObj := recordPath("default", args[0])
+	if errObj != nil {
+		return errObj
+	}
+	return mapRecords(ctx, "default", input, func(record map[string]interface{}) (interface{}, *Error) {
+		if current, found := accessField(record, path); found && current != nil {
+			return record, nil
+		}
+		value, errObj := recordValue(ctx, args[1], &Json{Value: record})
+		if errObj != nil {
+			return nil, errObj
+		}
+		return assignField("default", record, path, value)
+	})
+}
+
+// builtinReject implementa `reject <.campo> ...`: quita los campos indicados,
+// si existen. Es lo contrario de select.
+func builtinReject(ctx context.Context, input Object, args ...Object) Object {
+	if len(args) == 0 {
+		return newError("uso: reject <.campo1> <.campo2> ...")
+	}
+	var paths [][]pathSegment
+	for _, arg := range args {
+		path, errObj := recordPath("reject", arg)
+		if errObj != nil {
+			return errObj
+		}
+		paths = append(paths, path)
+	}
+	return mapRecords(ctx, "reject", input, func(record map[string]interface{}) (interface{}, *Error) {
+		var result interface{} = record
+		for _, path := range paths {
+			result, _ = removeField(result, path)
+		}
+		return result, nil
+	})
+}
+
+// builtinRename implementa `rename <.campo> <nombre>`: cambia el nombre de un
+// campo, que se queda en el mismo objeto: `rename .location.city town`.
+func builtinRename(ctx context.Context, input Object, args ...Object) Object {
+	if len(args) != 2 {
+		return newError("uso: rename <.campo> <nuevo-nombre>")
+	}
+	path, errObj := recordPath("rename", args[0])
+	if errObj != nil {
+		return errObj
+	}
+	if path[len(path)-1].kind != keySegment {
+		return newError("rename: la ruta debe terminar en un campo, se obtuvo '%s'", args[0].Inspect())
+	}
+	newName := strings.TrimPrefix(args[1].Inspect(), ".")
+	if newName == "" {
+		return newError("rename: el nuevo nombre no puede estar vacío")
+	}
+	newPath := append(append([]pathSegment{}, path[:len(path)-1]...), pathSegment{kind: keySegment, key: newName})
+	return mapRecords(ctx, "rename", input, func(record map[string]interface{}) (interface{}, *Error) {
+		value, found := accessField(record, path)
+		if !found {
+			return record, nil
+		}
+		result, _ := removeField(record, path)
+		updated, err := setField(result, newPath, value)
+		if err != nil {
+			return nil, newError("rename: %v", err)
+		}
+		return updated, nil
+	})
+}
@@ -0,0 +1,42 @@
+package evaluator
+
+import (
+	"strings"
+	"testing"
+)
+
+const nullAges = `echo '[{"n": "a", "age": null}, {"n": "b", "age": 40}, {"n": "c"}]'`
+
+func TestUpdate(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{nullAges + ` | update .age { $it + 1 } | get .age`, `[null,41]`},
+		{nullAges + ` | update .age 0 | get .age`, `[0,0]`},
+		{nullAges + ` | update .n { |v, r| "$v$r.n" } | get .n`, `["aa","bb","cc"]`},
+	}
+	for _, tt := range tests {
+		testEvalOutput(t, tt.input, tt.want)
+	}
+}
+
+func TestRecordBuiltins(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{nullAges + ` | insert .x 1 | get .x`, `[1,1,1]`},
+		{nullAges + ` | insert .label { "$it.n!" } | get .label`, `["a!","b!","c!"]`},
+		{nullAges + ` | default .age 0 | get .age`, `[0,40,0]`},
+		{nullAges + ` | reject .age | first`, `{"n":"a"}`},
+		{nullAges + ` | rename .n name | get .name`, `["a","b","c"]`},
+	}
+	for _, tt := range tests {
+		testEvalOutput(t, tt.input, tt.want)
+	}
+	result := testEval(t, nullAges+` | insert .n 1`)
+	if err, ok := result.(*Error); !ok || !strings.Contains(err.Message, "insert: el campo '.n' ya existe") {
+		t.Errorf("se esperaba un error de insert, se obtuvo %s", result.Inspect())
+	}
+}
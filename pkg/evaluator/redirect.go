@@ -0,0 +1,118 @@
+package evaluator
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/soyunomas/nxsh/pkg/parser"
+)
+
+// redirection guarda los ficheros abiertos para las redirecciones de un comando.
+// Un campo nil indica que ese flujo no se redirige.
+type redirection struct {
+	stdin  *os.File
+	stdout *os.File
+	stderr *os.File
+	// stderrToStdout indica que `2>&1` apareció antes de redirigir stdout, así
+	// que stderr va a donde habría ido stdout (la terminal o el pipe).
+	stderrToStdout bool
+	files          []*os.File
+}
+
+// openRedirects abre los ficheros de las redirecciones de un comando en el orden
+// en que aparecen, como en sh: `> f 2>&1` envía ambos flujos a f, mientras que
+// `2>&1 > f` envía stderr a la salida original.
+func openRedirects(ctx context.Context, cmdExpr *parser.CommandExpression, env *Environment) (*redirection, *Error) {
+	r := &redirection{}
+	for _, redirect := range cmdExpr.Redirects {
+		if redirect.Operator == string(parser.REDIRECT_ERR_OUT) {
+			r.stderr = r.stdout
+			r.stderrToStdout = r.stdout == nil
+			continue
+		}
+
+		target := Eval(ctx, redirect.Target, env)
+		if err, ok := target.(*Error); ok {
+			r.close()
+			return nil, err
+		}
+		path := target.Inspect()
+
+		var file *os.File
+		var err error
+		switch redirect.Operator {
+		case string(parser.LT):
+			file, err = os.Open(path)
+		case string(parser.GT), string(parser.REDIRECT_ERR):
+			file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		default: // >> y 2>>
+			file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		}
+		if err != nil {
+			r.close()
+			return nil, newError("redirección: %v", err)
+		}
+		r.files = append(r.files, file)
+
+		switch redirect.Operator {
+		case string(parser.LT):
+			r.stdin = file
+		case string(parser.GT), string(parser.REDIRECT_APPEND):
+			r.stdout = file
+		default:
+			r.stderr = file
+			r.stderrToStdout = false
+		}
+	}
+	return r, nil
+}
+
+// close cierra los ficheros abiertos por las redirecciones.
+func (r *redirection) close() {
+	for _, file := range r.files {
+		file.Close()
+	}
+}
+
+// closeRedirects cierra las redirecciones de todos los comandos de un pipeline.
+func closeRedirects(redirs []*redirection) {
+	for _, redir := range redirs {
+		redir.close()
+	}
+}
+
+// readInput lee la entrada redirigida con `<` de un comando interno y la
+// convierte en un objeto, detectando JSON como con la salida de los comandos externos.
+func (r *redirection) readInput() Object {
+	data, err := io.ReadAll(r.stdin)
+	if err != nil {
+		return newError("redirección: %v", err)
+	}
+	return outputToObject(data)
+}
+
+// writeOutput escribe el resultado de un comando interno en el fichero de la
+// redirección de stdout, con el mismo formato con el que se muestra en la terminal.
+func (r *redirection) writeOutput(result Object) Object {
+	if result == NULL {
+		return NULL
+	}
+	if _, err := io.WriteString(r.stdout, FormatObject(result)); err != nil {
+		return newError("redirección: %v", err)
+	}
+	return NULL
+}
+
+// writeError escribe el mensaje de error de un comando interno en el fichero de
+// la redirección de stderr (`2> f`, `2>> f` o `> f 2>&1`), como lo haría un
+// programa externo, y lo marca como informado para que la shell no lo repita
+// en la terminal. El resultado sigue siendo el error, para `$?`, `try` y `||`.
+func (r *redirection) writeError(err *Error) Object {
+	if _, writeErr := fmt.Fprintln(r.stderr, err.Message); writeErr != nil {
+		return newError("redirección: %v", writeErr)
+	}
+	err.Reported = true
+	return err
+}
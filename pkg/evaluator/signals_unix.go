@@ -0,0 +1,19 @@
+//go:build !windows
+
+package evaluator
+
+import "syscall"
+
+// signals son las señales que `kill` acepta por nombre, en orden de número.
+var signals = []namedSignal{
+	{"HUP", syscall.SIGHUP},
+	{"INT", syscall.SIGINT},
+	{"QUIT", syscall.SIGQUIT},
+	{"KILL", syscall.SIGKILL},
+	{"USR1", syscall.SIGUSR1},
+	{"USR2", syscall.SIGUSR2},
+	{"TERM", syscall.SIGTERM},
+	{"CONT", syscall.SIGCONT},
+	{"STOP", syscall.SIGSTOP},
+	{"TSTP", syscall.SIGTSTP},
+}
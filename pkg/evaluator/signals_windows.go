@@ -0,0 +1,13 @@
+//go:build windows
+
+package evaluator
+
+import "syscall"
+
+// signals son las señales que `kill` acepta por nombre. En Windows solo se
+// puede terminar un proceso: no hay forma de detenerlo ni de reanudarlo.
+var signals = []namedSignal{
+	{"INT", syscall.SIGINT},
+	{"KILL", syscall.SIGKILL},
+	{"TERM", syscall.SIGTERM},
+}
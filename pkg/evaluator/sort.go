@@ -0,0 +1,273 @@
+package evaluator
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/soyunomas/nxsh/pkg/parser"
+)
+
+// sortOptions son los flags comunes de `sort` y `sort-by`.
+type sortOptions struct {
+	reverse bool // -r: orden descendente
+	natural bool // --natural: los números dentro del texto se comparan por su valor
+}
+
+// parseSortFlags separa los flags del principio de args del resto de argumentos.
+func parseSortFlags(name string, args []Object) (sortOptions, []Object, *Error) {
+	var opts sortOptions
+	for len(args) > 0 {
+		flag, ok := args[0].(*String)
+		if !ok || !strings.HasPrefix(flag.Value, "-") {
+			break
+		}
+		switch flag.Value {
+		case "-r", "--reverse":
+			opts.reverse = true
+		// No hay forma corta: en `sort`, `-n` es el orden numérico del sistema.
+		case "--natural":
+			opts.natural = true
+		default:
+			return opts, nil, newError("%s: opción desconocida '%s'", name, flag.Value)
+		}
+		args = args[1:]
+	}
+	return opts, args, nil
+}
+
+// builtinSortBy implementa `sort-by [-r] [--natural] <.campo> [<.campo> ...]`: ordena
+// un array por uno o más campos, desempatando con los siguientes. El orden es
+// estable, y los elementos sin el campo (o con null) van siempre al final.
+func builtinSortBy(ctx context.Context, input Object, args ...Object) Object {
+	if input == nil {
+		return newError("sort-by: requiere una entrada de un pipeline")
+	}
+	opts, args, errObj := parseSortFlags("sort-by", args)
+	if errObj != nil {
+		return errObj
+	}
+	if len(args) == 0 {
+		return newError("uso: sort-by [-r] [--natural] <.campo> [<.campo> ...]")
+	}
+	items, errObj := inputArray("sort-by", input)
+	if errObj != nil {
+		return errObj
+	}
+	var paths [][]pathSegment
+	for _, arg := range args {
+		pathArg, ok := arg.(*String)
+		if !ok {
+			return newError("sort-by: el argumento de ruta debe ser una cadena, se obtuvo %s", arg.Type())
+		}
+		path, err := parsePath(pathArg.Value)
+		if err != nil {
+			return newError("sort-by: %v", err)
+		}
+		paths = append(paths, path)
+	}
+
+	// Las claves de cada elemento se extraen una sola vez, antes de ordenar.
+	type keyed struct {
+		item interface{}
+		keys []interface{}
+	}
+	rows := make([]keyed, len(items))
+	for i, item := range items {
+		if err := interrupted(ctx); err != nil {
+			return err
+		}
+		keys := make([]interface{}, len(paths))
+		for k, path := range paths {
+			keys[k], _ = accessField(item, path)
+		}
+		rows[i] = keyed{item: item, keys: keys}
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		for k := range paths {
+			if c := compareSortKeys(rows[i].keys[k], rows[j].keys[k], opts); c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+	results := make([]interface{}, len(rows))
+	for i, row := range rows {
+		results[i] = row.item
+	}
+	return &Json{Value: results}
+}
+
+// builtinSort implementa `sort [-r] [--natural]`: ordena un array de valores simples,
+// o las líneas de un texto (como la salida de un comando externo).
+func builtinSort(ctx context.Context, input Object, args ...Object) Object {
+	if input == nil {
+		return newError("sort: requiere una entrada de un pipeline")
+	}
+	opts, args, errObj := parseSortFlags("sort", args)
+	if errObj != nil {
+		return errObj
+	}
+	if len(args) != 0 {
+		return newError("uso: sort [-r] [--natural] (para ordenar por un campo, usa sort-by)")
+	}
+	if text, ok := input.(*String); ok {
+		lines := strings.Split(strings.TrimRight(text.Value, "\n"), "\n")
+		sort.SliceStable(lines, func(i, j int) bool {
+			return compareSortKeys(lines[i], lines[j], opts) < 0
+		})
+		return &String{Value: strings.Join(lines, "\n")}
+	}
+	items, errObj := inputArray("sort", input)
+	if errObj != nil {
+		return errObj
+	}
+	if err := interrupted(ctx); err != nil {
+		return err
+	}
+	results := append([]interface{}{}, items...)
+	sort.SliceStable(results, func(i, j int) bool {
+		return compareSortKeys(results[i], results[j], opts) < 0
+	})
+	return &Json{Value: results}
+}
+
+// sortIsExternal indica si `sort` ejecuta el programa del sistema: sin entrada
+// de un pipeline (`sort file.txt`) o con opciones que el builtin no tiene
+// (`cut -d: -f1,3 /etc/passwd | sort -t: -k2`, `du -s * | sort -n`).
+func sortIsExternal(args []parser.Expression, piped bool) bool {
+	return !piped || !onlyFlags(args, "-r", "--reverse", "--natural")
+}
+
+// inputArray devuelve los elementos de una entrada que debe ser un array JSON.
+func inputArray(name string, input Object) ([]interface{}, *Error) {
+	if jsonInput, ok := input.(*Json); ok {
+		if items, ok := jsonInput.Value.([]interface{}); ok {
+			return items, nil
+		}
+	}
+	return nil, newError("%s: la entrada debe ser un array JSON, se obtuvo %s", name, input.Type())
+}
+
+// compareSortKeys compara dos claves de ordenación según opts. Los null (y los
+// campos que faltan) van al final también en orden descendente.
+func compareSortKeys(a, b interface{}, opts sortOptions) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return 1
+	case b == nil:
+		return -1
+	}
+	c := compareValues(a, b, opts.natural)
+	if opts.reverse {
+		return -c
+	}
+	return c
+}
+
+// compareValues ordena dos valores de JSON teniendo en cuenta su tipo: los de
+// distinto tipo se ordenan como null < booleanos < números < texto < arrays y
+// objetos; los del mismo tipo, por su valor. Devuelve -1, 0 o 1.
+func compareValues(a, b interface{}, natural bool) int {
+	if ra, rb := typeRank(a), typeRank(b); ra != rb {
+		return compareInts(ra, rb)
+	}
+	switch a := a.(type) {
+	case nil:
+		return 0
+	case bool:
+		b := b.(bool)
+		if a == b {
+			return 0
+		}
+		if !a {
+			return -1
+		}
+		return 1
+	case float64:
+		b := b.(float64)
+		if a < b {
+			return -1
+		}
+		if a > b {
+			return 1
+		}
+		return 0
+	case string:
+		if natural {
+			return naturalCompare(a, b.(string))
+		}
+		return strings.Compare(a, b.(string))
+	default:
+		ja, _ := json.Marshal(a)
+		jb, _ := json.Marshal(b)
+		return strings.Compare(string(ja), string(jb))
+	}
+}
+
+func typeRank(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return 0
+	case bool:
+		return 1
+	case float64:
+		return 2
+	case string:
+		return 3
+	default:
+		return 4
+	}
+}
+
+func compareInts(a, b int) int {
+	if a < b {
+		return -1
+	}
+	if a > b {
+		return 1
+	}
+	return 0
+}
+
+// naturalCompare compara dos textos tratando cada secuencia de dígitos como un
+// número, de modo que "v2" va antes que "v10".
+func naturalCompare(a, b string) int {
+	for a != "" && b != "" {
+		if isDigitByte(a[0]) && isDigitByte(b[0]) {
+			na, ra := splitDigits(a)
+			nb, rb := splitDigits(b)
+			// Sin ceros a la izquierda, el número con más dígitos es el mayor.
+			ta, tb := strings.TrimLeft(na, "0"), strings.TrimLeft(nb, "0")
+			if c := compareInts(len(ta), len(tb)); c != 0 {
+				return c
+			}
+			if c := strings.Compare(ta, tb); c != 0 {
+				return c
+			}
+			a, b = ra, rb
+			continue
+		}
+		if a[0] != b[0] {
+			return compareInts(int(a[0]), int(b[0]))
+		}
+		a, b = a[1:], b[1:]
+	}
+	return compareInts(len(a), len(b))
+}
+
+func isDigitByte(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// splitDigits separa las cifras del principio de s del resto.
+func splitDigits(s string) (string, string) {
+	i := 0
+	for i < len(s) && isDigitByte(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
@@ -0,0 +1,17 @@
+package evaluator
+
+import "testing"
+
+func TestSort(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`[3, 1, 2] | sort`, `[1,2,3]`},
+		{`[3, 1, 2] | sort -r`, `[3,2,1]`},
+		{`[v10, v2, v1] | sort --natural`, `["v1","v2","v10"]`},
+	}
+	for _, tt := range tests {
+		testEvalOutput(t, tt.input, tt.want)
+	}
+}
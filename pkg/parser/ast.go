@@ -80,22 +80,27 @@ func (i *Identifier) String() string       { return i.Value }
 type ExpressionStatement struct {
 	Token      Token // el primer token de la expresión
 	Expression Expression
+	Background bool // terminado en `&`: se ejecuta como trabajo en segundo plano
 }
 
 func (es *ExpressionStatement) statementNode()       {}
 func (es *ExpressionStatement) TokenLiteral() string { return es.Token.Literal }
 func (es *ExpressionStatement) String() string {
-	if es.Expression != nil {
-		return es.Expression.String()
+	if es.Expression == nil {
+		return ""
 	}
-	return ""
+	if es.Background {
+		return es.Expression.String() + " &"
+	}
+	return es.Expression.String()
 }
 
 // CommandExpression representa un comando con sus argumentos.
 type CommandExpression struct {
-	Token Token        // El primer token, que es el nombre del comando.
-	Name  Expression   // Será un Identifier.
-	Args  []Expression // Argumentos del comando.
+	Token     Token        // El primer token, que es el nombre del comando.
+	Name      Expression   // Será un Identifier.
+	Args      []Expression // Argumentos del comando.
+	Redirects []*Redirect  // Redirecciones, en el orden en que aparecen.
 }
 
 func (ce *CommandExpression) expressionNode()      {}
@@ -106,10 +111,62 @@ func (ce *CommandExpression) String() string {
 	for _, arg := range ce.Args {
 		parts = append(parts, arg.String())
 	}
+	for _, redirect := range ce.Redirects {
+		parts = append(parts, redirect.String())
+	}
 	out.WriteString(strings.Join(parts, " "))
 	return out.String()
 }
 
+// Redirect representa una redirección de un comando: `> file`, `>> file`,
+// `< file`, `2> file`, `2>> file` o `2>&1` (que no tiene Target).
+type Redirect struct {
+	Token    Token // el operador
+	Operator string
+	Target   Expression
+}
+
+func (r *Redirect) String() string {
+	if r.Target == nil {
+		return r.Operator
+	}
+	return r.Operator + " " + r.Target.String()
+}
+
+// PredicateExpression representa el predicado de `where`: comparaciones
+// `.campo op valor` combinadas con `and`, `or`, `not` y paréntesis. Las
+// comparaciones, `and` y `or` son InfixExpression, y `not` una PrefixExpression.
+// Con IgnoreCase (`where -i ...`) las comparaciones de texto ignoran mayúsculas.
+type PredicateExpression struct {
+	Token      Token // el primer token del predicado
+	Predicate  Expression
+	IgnoreCase bool
+}
+
+func (pe *PredicateExpression) expressionNode()      {}
+func (pe *PredicateExpression) TokenLiteral() string { return pe.Token.Literal }
+func (pe *PredicateExpression) String() string {
+	if pe.IgnoreCase {
+		return "-i " + pe.Predicate.String()
+	}
+	return pe.Predicate.String()
+}
+
+// LogicalExpression representa `a && b` o `a || b`: el lado derecho solo se
+// evalúa si el izquierdo tuvo éxito (`&&`) o falló (`||`).
+type LogicalExpression struct {
+	Token    Token // el token '&&' o '||'
+	Operator string
+	Left     Expression
+	Right    Expression
+}
+
+func (le *LogicalExpression) expressionNode()      {}
+func (le *LogicalExpression) TokenLiteral() string { return le.Token.Literal }
+func (le *LogicalExpression) String() string {
+	return le.Left.String() + " " + le.Operator + " " + le.Right.String()
+}
+
 // PipelineExpression representa dos comandos conectados por un pipe.
 type PipelineExpression struct {
 	Token Token      // El token '|'
@@ -139,4 +196,323 @@ func (sl *StringLiteral) expressionNode()      {}
 func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
 func (sl *StringLiteral) String() string       { return `"` + sl.Token.Literal + `"` }
 
+// Variable representa una referencia a variable: $name, ${name} o $name.campo.
+type Variable struct {
+	Token Token  // el token VARIABLE
+	Name  string // nombre de la variable, sin el '$'
+	Path  string // ruta opcional dentro del valor (ej. ".location.city"), vacía si no hay
+}
+
+func (v *Variable) expressionNode()      {}
+func (v *Variable) TokenLiteral() string { return v.Token.Literal }
+func (v *Variable) String() string       { return "$" + v.Name + v.Path }
+
+// InterpolatedString representa un texto con referencias a variables, ya sea una
+// cadena entre comillas dobles ("hola $user") o una palabra suelta ($dir/file).
+type InterpolatedString struct {
+	Token Token        // el token STRING o IDENT original
+	Parts []Expression // StringLiteral y Variable, en orden
+}
+
+func (is *InterpolatedString) expressionNode()      {}
+func (is *InterpolatedString) TokenLiteral() string { return is.Token.Literal }
+func (is *InterpolatedString) String() string       { return `"` + is.Token.Literal + `"` }
+
+// ArrayLiteral representa una lista de valores: `["admin", "dev"]`.
+type ArrayLiteral struct {
+	Token    Token // el token '['
+	Elements []Expression
+}
+
+func (al *ArrayLiteral) expressionNode()      {}
+func (al *ArrayLiteral) TokenLiteral() string { return al.Token.Literal }
+func (al *ArrayLiteral) String() string {
+	var elements []string
+	for _, el := range al.Elements {
+		elements = append(elements, el.String())
+	}
+	return "[" + strings.Join(elements, ", ") + "]"
+}
+
+// EmptyRecordLiteral representa el registro vacío `{}`, del que se parte para
+// ir añadiendo campos: `reduce -f {} { |acc, u| ... }`. Un bloque sin
+// sentencias no tendría utilidad como valor; `{ || }` sigue siéndolo.
+type EmptyRecordLiteral struct {
+	Token Token // el token '{'
+}
+
+func (er *EmptyRecordLiteral) expressionNode()      {}
+func (er *EmptyRecordLiteral) TokenLiteral() string { return er.Token.Literal }
+func (er *EmptyRecordLiteral) String() string       { return "{}" }
+
+// CallExpression representa una llamada `nombre(args)`, como las agregaciones
+// de `aggregate count avg(.age)`.
+type CallExpression struct {
+	Token     Token // el nombre
+	Function  *Identifier
+	Arguments []Expression
+}
+
+func (ce *CallExpression) expressionNode()      {}
+func (ce *CallExpression) TokenLiteral() string { return ce.Token.Literal }
+func (ce *CallExpression) String() string {
+	var args []string
+	for _, a := range ce.Arguments {
+		args = append(args, a.String())
+	}
+	return ce.Function.String() + "(" + strings.Join(args, ", ") + ")"
+}
+
+// IntegerLiteral representa un número entero.
+type IntegerLiteral struct {
+	Token Token
+	Value int64
+}
+
+func (il *IntegerLiteral) expressionNode()      {}
+func (il *IntegerLiteral) TokenLiteral() string { return il.Token.Literal }
+func (il *IntegerLiteral) String() string       { return il.Token.Literal }
+
+// FloatLiteral representa un número decimal.
+type FloatLiteral struct {
+	Token Token
+	Value float64
+}
+
+func (fl *FloatLiteral) expressionNode()      {}
+func (fl *FloatLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FloatLiteral) String() string       { return fl.Token.Literal }
+
+// PrefixExpression representa un operador unario, como en `-5` o `- $a`.
+type PrefixExpression struct {
+	Token    Token // El token del operador, ej. '-'
+	Operator string
+	Right    Expression
+}
+
+func (pe *PrefixExpression) expressionNode()      {}
+func (pe *PrefixExpression) TokenLiteral() string { return pe.Token.Literal }
+func (pe *PrefixExpression) String() string {
+	if pe.Operator == "not" {
+		return "(not " + pe.Right.String() + ")"
+	}
+	return "(" + pe.Operator + pe.Right.String() + ")"
+}
+
+// InfixExpression representa un operador binario, como en `$a * 2`.
+type InfixExpression struct {
+	Token    Token // El token del operador, ej. '*'
+	Left     Expression
+	Operator string
+	Right    Expression
+}
+
+func (ie *InfixExpression) expressionNode()      {}
+func (ie *InfixExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *InfixExpression) String() string {
+	return "(" + ie.Left.String() + " " + ie.Operator + " " + ie.Right.String() + ")"
+}
+
+// Boolean representa los literales true y false.
+type Boolean struct {
+	Token Token
+	Value bool
+}
+
+func (b *Boolean) expressionNode()      {}
+func (b *Boolean) TokenLiteral() string { return b.Token.Literal }
+func (b *Boolean) String() string       { return b.Token.Literal }
+
+// BlockStatement representa una lista de statements entre llaves: { ... }
+type BlockStatement struct {
+	Token      Token // el token '{'
+	Statements []Statement
+}
+
+func (bs *BlockStatement) statementNode()       {}
+func (bs *BlockStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BlockStatement) String() string {
+	var parts []string
+	for _, s := range bs.Statements {
+		parts = append(parts, s.String())
+	}
+	return "{ " + strings.Join(parts, "; ") + " }"
+}
+
+// ClosureLiteral representa un bloque usado como valor, con parámetros
+// opcionales: `{ |acc, x| ... }`. Sin parámetros, el valor recibido es `$it`.
+type ClosureLiteral struct {
+	Token      Token // el token '{'
+	Parameters []*Identifier
+	Body       *BlockStatement
+}
+
+func (cl *ClosureLiteral) expressionNode()      {}
+func (cl *ClosureLiteral) TokenLiteral() string { return cl.Token.Literal }
+func (cl *ClosureLiteral) String() string {
+	if len(cl.Parameters) == 0 {
+		return cl.Body.String()
+	}
+	var params []string
+	for _, p := range cl.Parameters {
+		params = append(params, p.String())
+	}
+	return "{ |" + strings.Join(params, ", ") + "| " + strings.TrimPrefix(cl.Body.String(), "{ ")
+}
+
+// IfExpression representa `if <condición> { ... } else { ... }`.
+// Un `else if` se representa como un Alternative con un único IfExpression.
+type IfExpression struct {
+	Token       Token // el token 'if'
+	Condition   Expression
+	Consequence *BlockStatement
+	Alternative *BlockStatement
+}
+
+func (ie *IfExpression) expressionNode()      {}
+func (ie *IfExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IfExpression) String() string {
+	var out bytes.Buffer
+	out.WriteString("if ")
+	out.WriteString(ie.Condition.String())
+	out.WriteString(" ")
+	out.WriteString(ie.Consequence.String())
+	if ie.Alternative != nil {
+		out.WriteString(" else ")
+		out.WriteString(ie.Alternative.String())
+	}
+	return out.String()
+}
+
+// ForStatement representa `for <variable> in <iterable> { ... }`.
+type ForStatement struct {
+	Token    Token // el token 'for'
+	Variable *Identifier
+	Iterable Expression
+	Body     *BlockStatement
+}
+
+func (fs *ForStatement) statementNode()       {}
+func (fs *ForStatement) TokenLiteral() string { return fs.Token.Literal }
+func (fs *ForStatement) String() string {
+	return "for " + fs.Variable.String() + " in " + fs.Iterable.String() + " " + fs.Body.String()
+}
+
+// BreakStatement representa `break` dentro de un bucle.
+type BreakStatement struct {
+	Token Token
+}
+
+func (bs *BreakStatement) statementNode()       {}
+func (bs *BreakStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BreakStatement) String() string       { return bs.Token.Literal }
+
+// ContinueStatement representa `continue` dentro de un bucle.
+type ContinueStatement struct {
+	Token Token
+}
+
+func (cs *ContinueStatement) statementNode()       {}
+func (cs *ContinueStatement) TokenLiteral() string { return cs.Token.Literal }
+func (cs *ContinueStatement) String() string       { return cs.Token.Literal }
+
+// DefStatement representa la definición de una función: `def name(a, b) { ... }`.
+type DefStatement struct {
+	Token      Token // el token 'def'
+	Name       *Identifier
+	Parameters []*Identifier
+	Body       *BlockStatement
+}
+
+func (ds *DefStatement) statementNode()       {}
+func (ds *DefStatement) TokenLiteral() string { return ds.Token.Literal }
+func (ds *DefStatement) String() string {
+	var params []string
+	for _, p := range ds.Parameters {
+		params = append(params, p.String())
+	}
+	return "def " + ds.Name.String() + "(" + strings.Join(params, ", ") + ") " + ds.Body.String()
+}
+
+// ReturnStatement representa `return [<expresión>]` dentro de una función.
+type ReturnStatement struct {
+	Token       Token // el token 'return'
+	ReturnValue Expression
+}
+
+func (rs *ReturnStatement) statementNode()       {}
+func (rs *ReturnStatement) TokenLiteral() string { return rs.Token.Literal }
+func (rs *ReturnStatement) String() string {
+	if rs.ReturnValue != nil {
+		return "return " + rs.ReturnValue.String()
+	}
+	return "return"
+}
+
+// AliasStatement representa `alias ll = ls -l`: el nombre pasa a expandirse
+// al comando indicado, al que se añaden los argumentos de cada llamada.
+type AliasStatement struct {
+	Token   Token // el token 'alias'
+	Name    *Identifier
+	Command *CommandExpression
+}
+
+func (as *AliasStatement) statementNode()       {}
+func (as *AliasStatement) TokenLiteral() string { return as.Token.Literal }
+func (as *AliasStatement) String() string {
+	return "alias " + as.Name.String() + " = " + as.Command.String()
+}
+
+// StatementLine devuelve la línea de la entrada en la que empieza un statement,
+// o 0 si no se conoce.
+func StatementLine(stmt Statement) int {
+	switch stmt := stmt.(type) {
+	case *LetStatement:
+		return stmt.Token.Line
+	case *ExpressionStatement:
+		return stmt.Token.Line
+	case *BlockStatement:
+		return stmt.Token.Line
+	case *ForStatement:
+		return stmt.Token.Line
+	case *BreakStatement:
+		return stmt.Token.Line
+	case *ContinueStatement:
+		return stmt.Token.Line
+	case *DefStatement:
+		return stmt.Token.Line
+	case *ReturnStatement:
+		return stmt.Token.Line
+	case *AliasStatement:
+		return stmt.Token.Line
+	default:
+		return 0
+	}
+}
+
+// TryExpression representa `try { ... } catch err { ... }`. Tanto la variable
+// como el bloque catch son opcionales.
+type TryExpression struct {
+	Token         Token // el token 'try'
+	Block         *BlockStatement
+	CatchVariable *Identifier
+	CatchBlock    *BlockStatement
+}
+
+func (te *TryExpression) expressionNode()      {}
+func (te *TryExpression) TokenLiteral() string { return te.Token.Literal }
+func (te *TryExpression) String() string {
+	var out bytes.Buffer
+	out.WriteString("try ")
+	out.WriteString(te.Block.String())
+	if te.CatchBlock != nil {
+		out.WriteString(" catch ")
+		if te.CatchVariable != nil {
+			out.WriteString(te.CatchVariable.String() + " ")
+		}
+		out.WriteString(te.CatchBlock.String())
+	}
+	return out.String()
+}
+
 // LA FUNCIÓN ToCommand HA SIDO ELIMINADA DE AQUÍ
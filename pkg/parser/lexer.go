@@ -1,6 +1,9 @@
 package parser
 
-import "unicode"
+import (
+	"strings"
+	"unicode"
+)
 
 // Lexer se encarga de tokenizar la entrada.
 type Lexer struct {
@@ -8,17 +11,43 @@ type Lexer struct {
 	position     int  // posición actual en la entrada (apunta al carácter actual)
 	readPosition int  // próxima posición a leer (después del carácter actual)
 	ch           rune // carácter actual bajo inspección
+	line         int  // línea del carácter actual, empezando en 1
+
+	// Las comas solo separan elementos dentro de una lista: `[a, b]`, los
+	// parámetros `def f(a, b)` o `{ |a, b| ... }` y los argumentos de una
+	// llamada `avg(.age)`. En el resto de palabras son un carácter más
+	// (`cut -d, -f1,2`). lists guarda, por cada '(', '[' o '{' abierto, si es
+	// una lista, y params si se está dentro de los `|...|` de un bloque.
+	lists  []bool
+	params bool
+	// prev y prev2 son los tipos de los dos últimos tokens, y spaced indica si
+	// había espacios antes del token actual.
+	prev, prev2 TokenType
+	spaced      bool
+
+	// Los operadores aritméticos pegados a sus operandos (`(2+3)*2`, `$x-1`)
+	// solo se reconocen en una expresión: un statement, un grupo `( )` o un
+	// bloque que no empieza por un comando. En los argumentos de un comando
+	// `-l`, `a+b` o `$dir/file.txt` son palabras. start indica que el siguiente
+	// token empieza un statement, expr si se está en una expresión y modes
+	// guarda expr por cada '(', '[' o '{' abierto.
+	start bool
+	expr  bool
+	modes []bool
 }
 
 // NewLexer crea una nueva instancia de Lexer.
 func NewLexer(input string) *Lexer {
-	l := &Lexer{input: input}
+	l := &Lexer{input: input, line: 1, start: true}
 	l.readChar() // Inicializa la posición y el primer carácter
 	return l
 }
 
 // readChar avanza la posición en el input y lee el siguiente carácter.
 func (l *Lexer) readChar() {
+	if l.ch == '\n' {
+		l.line++
+	}
 	if l.readPosition >= len(l.input) {
 		l.ch = 0 // ASCII para "NUL", indica EOF
 	} else {
@@ -36,11 +65,98 @@ func (l *Lexer) peekChar() rune {
 	return rune(l.input[l.readPosition])
 }
 
-// NextToken tokeniza el siguiente fragmento de la entrada.
+// NextToken tokeniza el siguiente fragmento de la entrada y anota en el token
+// la línea en la que empieza.
 func (l *Lexer) NextToken() Token {
+	position := l.position
+	l.skipWhitespace()
+	l.spaced = l.position != position
+	line := l.line
+	// Hasta ver su primer token, un statement se lee como una expresión.
+	if l.start {
+		l.expr = true
+	}
+	tok := l.readToken()
+	tok.Line = line
+	tok.Spaced = l.spaced
+	l.trackLists(tok.Type)
+	l.trackMode(tok.Type)
+	l.prev2, l.prev = l.prev, tok.Type
+	return tok
+}
+
+// trackMode actualiza si se está leyendo una expresión con el token que se
+// acaba de leer (ver Lexer.expr).
+func (l *Lexer) trackMode(t TokenType) {
+	switch t {
+	case LPAREN, LBRACE, LBRACKET:
+		l.modes = append(l.modes, l.expr)
+		// Un grupo o un bloque empiezan statements; una lista sigue en el modo de fuera.
+		l.start = t != LBRACKET
+		return
+	case RPAREN, RBRACE, RBRACKET:
+		if len(l.modes) > 0 {
+			l.expr = l.modes[len(l.modes)-1]
+			l.modes = l.modes[:len(l.modes)-1]
+		}
+		l.start = false
+		return
+	}
+	if l.start && IsCommandStart(t) {
+		l.expr = false
+	}
+	switch t {
+	case NEWLINE, SEMICOLON, PIPE, AND, OR, AMPERSAND, ASSIGN, IF, IN, RETURN:
+		l.start = true
+	default:
+		l.start = false
+	}
+}
+
+// trackLists actualiza las listas abiertas con el token que se acaba de leer.
+func (l *Lexer) trackLists(t TokenType) {
+	switch t {
+	case LBRACKET:
+		l.lists = append(l.lists, true)
+	case LPAREN:
+		// `f(a, b)` (pegado al nombre) o `def f (a, b)`, pero no `(ls a,b)`.
+		list := l.prev == IDENT && (!l.spaced || l.prev2 == DEF)
+		l.lists = append(l.lists, list)
+	case LBRACE:
+		l.lists = append(l.lists, false)
+	case RBRACKET, RPAREN, RBRACE:
+		if len(l.lists) > 0 {
+			l.lists = l.lists[:len(l.lists)-1]
+		}
+	case PIPE:
+		// El primer '|' de un bloque abre sus parámetros y el siguiente los cierra.
+		l.params = !l.params && l.prev == LBRACE
+	}
+}
+
+// inList indica si las comas separan elementos en la posición actual.
+func (l *Lexer) inList() bool {
+	return l.params || (len(l.lists) > 0 && l.lists[len(l.lists)-1])
+}
+
+// isWordChar indica si ch continúa la palabra que se está leyendo: como
+// isIdentifierChar, pero dentro de una lista la coma la termina.
+func (l *Lexer) isWordChar(ch rune) bool {
+	if ch == ',' {
+		return !l.inList()
+	}
+	return isIdentifierChar(ch)
+}
+
+// readToken lee el token que empieza en el carácter actual.
+func (l *Lexer) readToken() Token {
 	var tok Token
 
-	l.skipWhitespace()
+	if l.atOperator() && (l.prev == RPAREN || l.prev == INT || l.prev == FLOAT || l.prev == VARIABLE) {
+		tok = Token{Type: operators[string(l.ch)], Literal: string(l.ch)}
+		l.readChar()
+		return tok
+	}
 
 	switch l.ch {
 	case '=':
@@ -48,13 +164,30 @@ func (l *Lexer) NextToken() Token {
 			ch := l.ch
 			l.readChar()
 			tok = Token{Type: EQ, Literal: string(ch) + string(l.ch)}
+		} else if l.peekChar() == '~' {
+			l.readChar()
+			tok = Token{Type: MATCH, Literal: "=~"}
 		} else {
 			tok = newToken(ASSIGN, l.ch)
 		}
 	case '|':
-		tok = newToken(PIPE, l.ch)
+		if l.peekChar() == '|' {
+			l.readChar()
+			tok = Token{Type: OR, Literal: "||"}
+		} else {
+			tok = newToken(PIPE, l.ch)
+		}
+	case '&':
+		if l.peekChar() == '&' {
+			l.readChar()
+			tok = Token{Type: AND, Literal: "&&"}
+		} else {
+			tok = newToken(AMPERSAND, l.ch)
+		}
 	case ';':
 		tok = newToken(SEMICOLON, l.ch)
+	case '\n':
+		tok = newToken(NEWLINE, l.ch)
 	case '(':
 		tok = newToken(LPAREN, l.ch)
 	case ')':
@@ -68,15 +201,26 @@ func (l *Lexer) NextToken() Token {
 	case ']':
 		tok = newToken(RBRACKET, l.ch)
 	case ',':
-		tok = newToken(COMMA, l.ch)
+		// Una coma suelta (`select .a, .b`) o en una lista separa; pegada a una
+		// palabra forma parte de ella (`-t,`).
+		if l.inList() || !isIdentifierChar(l.peekChar()) {
+			tok = newToken(COMMA, l.ch)
+		} else {
+			tok.Literal = l.readIdentifier()
+			tok.Type = IDENT
+			return tok
+		}
 	case '.':
-		// Un punto puede ser un token por sí mismo (para `get`) o parte de un identificador.
-		// Si está seguido por espacio o nada, es un token. Si no, será parte de un identificador.
-		if !isIdentifierChar(l.peekChar()) {
+		// Un punto puede ser un token por sí mismo (para `get`) o el inicio de una
+		// ruta. Si está seguido por espacio o nada, es un token. Si no, la ruta se
+		// lee entera, con sus índices y claves entre comillas, como un identificador.
+		if l.atRangeOperator() {
+			l.readChar()
+			tok = Token{Type: DOTDOT, Literal: ".."}
+		} else if (!isIdentifierChar(l.peekChar()) || l.peekChar() == ',') && l.peekChar() != '[' && l.peekChar() != '"' {
 			tok = newToken(DOT, l.ch)
 		} else {
-			// Cae en el caso default para ser leído como parte de un identificador.
-			tok.Literal = l.readIdentifier()
+			tok.Literal = l.readPath()
 			tok.Type = LookupIdent(tok.Literal)
 			return tok
 		}
@@ -85,6 +229,9 @@ func (l *Lexer) NextToken() Token {
 			ch := l.ch
 			l.readChar()
 			tok = Token{Type: GTE, Literal: string(ch) + string(l.ch)}
+		} else if l.peekChar() == '>' {
+			l.readChar()
+			tok = Token{Type: REDIRECT_APPEND, Literal: ">>"}
 		} else {
 			tok = newToken(GT, l.ch)
 		}
@@ -101,24 +248,48 @@ func (l *Lexer) NextToken() Token {
 			ch := l.ch
 			l.readChar()
 			tok = Token{Type: NEQ, Literal: string(ch) + string(l.ch)}
+		} else if l.peekChar() == '~' {
+			l.readChar()
+			tok = Token{Type: NOT_MATCH, Literal: "!~"}
 		} else {
 			tok = newToken(ILLEGAL, l.ch) // '!' solo no es válido por ahora
 		}
-	case '"', '\'':
+	case '"':
 		tok.Type = STRING
 		tok.Literal = l.readString(l.ch)
+	case '\'':
+		tok.Type = RAWSTRING
+		tok.Literal = l.readString(l.ch)
+	case '$':
+		// `$name` es una referencia a variable; si la palabra continúa después
+		// (ej. `$dir/file.txt`) se lee entera y el parser la interpola.
+		if isVariableChar(l.peekChar()) || l.peekChar() == '{' || l.peekChar() == '?' {
+			start := *l
+			literal := l.readVariable()
+			if !l.isWordChar(l.ch) || l.atRangeOperator() || l.atOperator() {
+				return Token{Type: VARIABLE, Literal: literal}
+			}
+			*l = start
+		}
+		tok.Literal = l.readIdentifier()
+		tok.Type = IDENT
+		return tok
 	case 0:
 		tok.Literal = ""
 		tok.Type = EOF
 	default:
-		if isIdentifierChar(l.ch) {
+		if l.ch == '2' && l.peekChar() == '>' {
+			return l.readStderrRedirect()
+		}
+		if isDigit(l.ch) || (l.ch == '-' && isDigit(l.peekChar())) {
+			if tok, ok := l.readNumber(); ok {
+				return tok
+			}
+		}
+		if l.isWordChar(l.ch) {
 			tok.Literal = l.readIdentifier()
 			tok.Type = LookupIdent(tok.Literal) // Verifica si es una palabra clave
 			return tok
-		} else if isDigit(l.ch) {
-			tok.Type = INT
-			tok.Literal = l.readNumber()
-			return tok
 		} else {
 			tok = newToken(ILLEGAL, l.ch)
 		}
@@ -133,29 +304,187 @@ func newToken(tokenType TokenType, ch rune) Token {
 	return Token{Type: tokenType, Literal: string(ch)}
 }
 
-// skipWhitespace avanza el lexer pasando los espacios en blanco.
+// skipWhitespace avanza el lexer pasando los espacios en blanco, los comentarios
+// (de '#' hasta el final de la línea) y las continuaciones de línea ('\' + salto).
+// Los saltos de línea no se saltan: separan statements y se emiten como NEWLINE.
 func (l *Lexer) skipWhitespace() {
-	for l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r' {
-		l.readChar()
+	for {
+		switch {
+		case l.ch == ' ' || l.ch == '\t' || l.ch == '\r':
+			l.readChar()
+		case l.ch == '\\' && l.peekChar() == '\n':
+			l.readChar()
+			l.readChar()
+		case l.ch == '#':
+			for l.ch != '\n' && l.ch != 0 {
+				l.readChar()
+			}
+		default:
+			return
+		}
 	}
 }
 
 // readIdentifier lee un identificador (o palabra clave) hasta que encuentra un no-letra/dígito.
+// Las referencias `${name}` dentro de la palabra se leen completas, llaves incluidas.
 func (l *Lexer) readIdentifier() string {
 	position := l.position
-	for isIdentifierChar(l.ch) {
+	for l.isWordChar(l.ch) {
+		if l.ch == '$' && l.peekChar() == '{' {
+			l.readVariable()
+			continue
+		}
 		l.readChar()
 	}
 	return l.input[position:l.position]
 }
 
-// readNumber lee un número (entero por ahora) hasta que encuentra un no-dígito.
-func (l *Lexer) readNumber() string {
+// readPath lee una ruta que empieza en el '.' actual. Además de los caracteres
+// de un identificador incluye los índices entre corchetes (`.items[0]`,
+// `.tags[-2:]`, `.users[*]`) y las claves entre comillas (`."con espacios"`).
+func (l *Lexer) readPath() string {
+	position := l.position
+	for {
+		switch {
+		case l.ch == '[':
+			for l.ch != ']' && l.ch != 0 {
+				l.readChar()
+			}
+			if l.ch == ']' {
+				l.readChar()
+			}
+		case l.ch == '"' && l.input[l.position-1] == '.':
+			l.readString(l.ch)
+			if l.ch == '"' {
+				l.readChar()
+			}
+		case l.ch == '$' && l.peekChar() == '{':
+			l.readVariable()
+		case isIdentifierChar(l.ch) && l.ch != ',':
+			l.readChar()
+		default:
+			return l.input[position:l.position]
+		}
+	}
+}
+
+// readVariable lee una referencia a variable que empieza en el '$' actual y
+// devuelve el nombre junto con su ruta opcional, sin '$' ni llaves:
+// `$user` -> "user", `$user.name` -> "user.name", `$xs[0]` -> "xs[0]",
+// `${user.name}` -> "user.name".
+// `$?` (el código de salida del último comando) devuelve "?".
+func (l *Lexer) readVariable() string {
+	l.readChar() // Salta el '$'
+	if l.ch == '?' {
+		l.readChar()
+		return "?"
+	}
+	if l.ch == '{' {
+		position := l.position + 1
+		for l.ch != '}' && l.ch != 0 {
+			l.readChar()
+		}
+		name := l.input[position:l.position]
+		if l.ch == '}' {
+			l.readChar()
+		}
+		return name
+	}
 	position := l.position
+	for isVariableChar(l.ch) {
+		l.readChar()
+	}
+	// Ruta opcional, con la sintaxis de `get`: `.campo` (solo si tras el punto
+	// sigue un nombre) e índices o rangos entre corchetes: `$u.tags[1]`, `$xs[-2:]`.
+	for {
+		switch {
+		case l.ch == '.' && isVariableChar(l.peekChar()):
+			l.readChar()
+			for isVariableChar(l.ch) {
+				l.readChar()
+			}
+		case l.ch == '[' && l.atIndex():
+			for l.ch != ']' {
+				l.readChar()
+			}
+			l.readChar()
+		default:
+			return l.input[position:l.position]
+		}
+	}
+}
+
+// atIndex indica si el '[' actual abre un índice que se cierra en la misma
+// palabra, sin espacios: `[0]`, `[1:3]`, `[*]`.
+func (l *Lexer) atIndex() bool {
+	end := strings.IndexAny(l.input[l.position:], "] \t\n")
+	return end > 0 && l.input[l.position+end] == ']'
+}
+
+// readNumber intenta leer un número entero o decimal, con signo opcional.
+// Si tras los dígitos la palabra continúa (ej. `2fa`, `1.2.3`, o `10-3` en los
+// argumentos de un comando), restaura la posición y devuelve ok=false para que
+// se lea como un identificador.
+func (l *Lexer) readNumber() (Token, bool) {
+	start := *l
+	position := l.position
+	tokType := INT
+	if l.ch == '-' {
+		l.readChar()
+	}
 	for isDigit(l.ch) {
 		l.readChar()
 	}
-	return l.input[position:l.position]
+	if l.ch == '.' && isDigit(l.peekChar()) {
+		tokType = FLOAT
+		l.readChar()
+		for isDigit(l.ch) {
+			l.readChar()
+		}
+	}
+	if l.isWordChar(l.ch) && !l.atRangeOperator() && !l.atOperator() {
+		*l = start
+		return Token{}, false
+	}
+	return Token{Type: tokType, Literal: l.input[position:l.position]}, true
+}
+
+// readStderrRedirect lee una redirección de stderr que empieza en el '2' actual:
+// `2>`, `2>>` o `2>&1`.
+func (l *Lexer) readStderrRedirect() Token {
+	for _, tokType := range []TokenType{REDIRECT_ERR_OUT, REDIRECT_ERR_APPEND, REDIRECT_ERR} {
+		literal := string(tokType)
+		if strings.HasPrefix(l.input[l.position:], literal) {
+			for range literal {
+				l.readChar()
+			}
+			return Token{Type: tokType, Literal: literal}
+		}
+	}
+	return Token{}
+}
+
+// atRangeOperator indica si el lexer está sobre un `..` de rango: en una
+// expresión (también el iterable de un `for`) y seguido de un número, una
+// variable o un paréntesis (`1..10`, `1..$n`). Así `cd ..`, `../dir` o
+// `echo 1..3` siguen siendo palabras normales.
+func (l *Lexer) atRangeOperator() bool {
+	if !l.expr || l.ch != '.' || l.peekChar() != '.' || l.readPosition+1 >= len(l.input) {
+		return false
+	}
+	next := rune(l.input[l.readPosition+1])
+	return isDigit(next) || next == '-' || next == '$' || next == '('
+}
+
+// atOperator indica si el carácter actual es un operador aritmético pegado a
+// un operando (`$x*3`, `2+3`): solo en una expresión y si le sigue algo que
+// puede empezar otro operando, de modo que `$dir/file.txt` sigue siendo una palabra.
+func (l *Lexer) atOperator() bool {
+	if !l.expr || !strings.ContainsRune("+-*/%", l.ch) {
+		return false
+	}
+	next := l.peekChar()
+	return isDigit(next) || next == '$' || next == '(' || next == '-' || next == ' ' || next == '\t'
 }
 
 // readString lee una cadena entre comillas (simples o dobles).
@@ -172,15 +501,22 @@ func (l *Lexer) readString(quote rune) string {
 
 // isIdentifierChar verifica si el rune es un carácter válido para un identificador o argumento.
 // CORREGIDO: Esta es la nueva definición, mucho más permisiva.
+// '<' y '>' no forman parte de las palabras porque son redirecciones también
+// sin espacios (`ls >out.txt`). La coma sí, salvo dentro de una lista (ver isWordChar).
 func isIdentifierChar(ch rune) bool {
 	switch ch {
-	case ' ', '\t', '\n', '\r', '|', '=', '(', ')', '{', '}', '[', ']', '"', '\'', 0:
+	case ' ', '\t', '\n', '\r', '|', '&', ';', '=', '<', '>', '(', ')', '{', '}', '[', ']', '"', '\'', 0:
 		return false
 	default:
 		return true
 	}
 }
 
+// isVariableChar verifica si el rune puede formar parte del nombre de una variable.
+func isVariableChar(ch rune) bool {
+	return ch == '_' || ('a' <= ch && ch <= 'z') || ('A' <= ch && ch <= 'Z') || ('0' <= ch && ch <= '9')
+}
+
 // isDigit verifica si el rune es un dígito.
 func isDigit(ch rune) bool {
 	return unicode.IsDigit(ch)
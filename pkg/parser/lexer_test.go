@@ -0,0 +1,74 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+)
+
+// tokens devuelve los tokens de input, sin el EOF final, como `TIPO:literal`.
+func tokens(input string) []string {
+	l := NewLexer(input)
+	var result []string
+	for tok := l.NextToken(); tok.Type != EOF; tok = l.NextToken() {
+		result = append(result, string(tok.Type)+":"+tok.Literal)
+	}
+	return result
+}
+
+func TestLexer(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`ls -l`, `IDENT:ls IDENT:-l`},
+		{`echo a,b`, `IDENT:echo IDENT:a,b`},
+		{`sort -t, -k2`, `IDENT:sort IDENT:-t, IDENT:-k2`},
+		{`cut -d, -f1,2`, `IDENT:cut IDENT:-d, IDENT:-f1,2`},
+		{`echo ,x 1,2`, `IDENT:echo IDENT:,x IDENT:1,2`},
+		{`(echo a,b)`, `(:( IDENT:echo IDENT:a,b ):)`},
+		{`[a, 1,2]`, `[:[ IDENT:a ,:, INT:1 ,:, INT:2 ]:]`},
+		{`[$x, "y"]`, `[:[ VARIABLE:x ,:, STRING:y ]:]`},
+		{`def f(a, b) { a,b }`, `DEF:def IDENT:f (:( IDENT:a ,:, IDENT:b ):) {:{ IDENT:a,b }:}`},
+		{`{ |acc, x| $acc,$x }`, `{:{ |:| IDENT:acc ,:, IDENT:x |:| IDENT:$acc,$x }:}`},
+		{`aggregate avg(.age)`, `IDENT:aggregate IDENT:avg (:( IDENT:.age ):)`},
+		{`select .name, .age`, `SELECT:select IDENT:.name ,:, IDENT:.age`},
+		{`get .items[0]."a b"`, `GET:get IDENT:.items[0]."a b"`},
+		{`get .`, `GET:get .:.`},
+		{`let x = 1.5 + -2`, `LET:let IDENT:x =:= FLOAT:1.5 +:+ INT:-2`},
+		{`(2+3)*2`, `(:( INT:2 +:+ INT:3 ):) *:* INT:2`},
+		{`$x*3; $x-1; $x -1`, `VARIABLE:x *:* INT:3 ;:; VARIABLE:x -:- INT:1 ;:; VARIABLE:x -:- INT:1`},
+		{`-(1)`, `-:- (:( INT:1 ):)`},
+		{`echo 1+2 $x-1 a*b (5-1)`, `IDENT:echo IDENT:1+2 IDENT:$x-1 IDENT:a*b (:( INT:5 -:- INT:1 ):)`},
+		{`$dir/run.sh -1`, `IDENT:$dir/run.sh INT:-1`},
+		{`for i in 1..$n`, `FOR:for IDENT:i IN:in INT:1 ..:.. VARIABLE:n`},
+		{`cd ..`, `CD:cd IDENT:..`},
+		{`echo 1..3 a..b $x..2`, `IDENT:echo IDENT:1..3 IDENT:a..b IDENT:$x..2`},
+		{`let r = 1..3; echo (1..$n)`, `LET:let IDENT:r =:= INT:1 ..:.. INT:3 ;:; IDENT:echo (:( INT:1 ..:.. VARIABLE:n ):)`},
+		{`kill -0 %1 -TERM`, `IDENT:kill INT:-0 IDENT:%1 IDENT:-TERM`},
+		{`ls >out.txt 2>&1`, `IDENT:ls >:> IDENT:out.txt 2>&1:2>&1`},
+		{`cmd 2>> err.log`, `IDENT:cmd 2>>:2>> IDENT:err.log`},
+		{`a && b || c &`, `IDENT:a &&:&& IDENT:b ||:|| IDENT:c &:&`},
+		{`where .n =~ '^a' and .m !~ x`, `WHERE:where IDENT:.n =~:=~ RAWSTRING:^a IDENT:and IDENT:.m !~:!~ IDENT:x`},
+		{`echo $dir/file.txt $?`, `IDENT:echo IDENT:$dir/file.txt VARIABLE:?`},
+		{`echo $u.tags[1] $xs[-2:].name "$u.a[0]"`, `IDENT:echo VARIABLE:u.tags[1] VARIABLE:xs[-2:].name STRING:$u.a[0]`},
+		{`echo $x[ a]`, `IDENT:echo VARIABLE:x [:[ IDENT:a ]:]`},
+		{"echo a # comentario\necho b", "IDENT:echo IDENT:a NEWLINE:\n IDENT:echo IDENT:b"},
+	}
+	for _, tt := range tests {
+		got := strings.Join(tokens(tt.input), " ")
+		if got != tt.want {
+			t.Errorf("%q:\n  se obtuvo  %s\n  se esperaba %s", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestLexerLines(t *testing.T) {
+	l := NewLexer("a\nb \\\n c")
+	want := []int{1, 1, 2, 3}
+	for i, line := range want {
+		tok := l.NextToken()
+		if tok.Line != line {
+			t.Errorf("token %d (%q): línea %d, se esperaba %d", i, tok.Literal, tok.Line, line)
+		}
+	}
+}
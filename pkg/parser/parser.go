@@ -2,39 +2,154 @@ package parser
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 )
 
+// Precedencias de los operadores, de menor a mayor.
+const (
+	_ int = iota
+	LOWEST
+	EQUALS      // == !=
+	LESSGREATER // > < >= <=
+	RANGE       // ..
+	SUM         // + -
+	PRODUCT     // * / %
+	PREFIX      // -X
+)
+
+var precedences = map[TokenType]int{
+	EQ:       EQUALS,
+	NEQ:      EQUALS,
+	GT:       LESSGREATER,
+	LT:       LESSGREATER,
+	GTE:      LESSGREATER,
+	LTE:      LESSGREATER,
+	DOTDOT:   RANGE,
+	PLUS:     SUM,
+	MINUS:    SUM,
+	ASTERISK: PRODUCT,
+	SLASH:    PRODUCT,
+	PERCENT:  PRODUCT,
+}
+
+type (
+	prefixParseFn func() Expression
+	infixParseFn  func(Expression) Expression
+)
+
 // Parser toma un lexer y construye un AST.
 type Parser struct {
 	l      *Lexer
 	errors []string
 
+	// multiline indica si la entrada tiene varias líneas (un script o un rc);
+	// en ese caso los errores indican la línea en la que se producen.
+	multiline bool
+	// statementErrors es el número de errores antes del statement que se está
+	// parseando: de cada statement solo se informa el primer error, no los que
+	// provoca en cascada.
+	statementErrors int
+
 	curToken  Token
 	peekToken Token
+
+	// loopDepth cuenta los bucles abiertos, para rechazar break/continue fuera de ellos.
+	// funcDepth hace lo mismo con las funciones y `return`.
+	loopDepth int
+	funcDepth int
+
+	// inHeader indica que se está parseando la condición de un `if` o el
+	// iterable de un `for`, donde el primer `{` abre el cuerpo y no un bloque
+	// como argumento: `if test -f x { ... }`.
+	inHeader bool
+
+	// Funciones del parser de expresiones (Pratt), indexadas por tipo de token.
+	prefixParseFns map[TokenType]prefixParseFn
+	infixParseFns  map[TokenType]infixParseFn
 }
 
 // NewParser crea una nueva instancia del Parser.
 func NewParser(l *Lexer) *Parser {
 	p := &Parser{
-		l:      l,
-		errors: []string{},
+		l:         l,
+		errors:    []string{},
+		multiline: strings.Contains(strings.TrimRight(l.input, "\n"), "\n"),
+	}
+
+	p.prefixParseFns = make(map[TokenType]prefixParseFn)
+	for _, t := range []TokenType{IDENT, STRING, RAWSTRING, VARIABLE, DOT} {
+		p.registerPrefix(t, p.parsePrimaryExpression)
 	}
+	p.registerPrefix(TRUE, p.parseBoolean)
+	p.registerPrefix(FALSE, p.parseBoolean)
+	p.registerPrefix(IF, p.parseIfExpression)
+	p.registerPrefix(TRY, p.parseTryExpression)
+	p.registerPrefix(INT, p.parseIntegerLiteral)
+	p.registerPrefix(FLOAT, p.parseFloatLiteral)
+	p.registerPrefix(MINUS, p.parsePrefixExpression)
+	p.registerPrefix(LPAREN, p.parseGroupedExpression)
+	p.registerPrefix(LBRACKET, p.parseArrayLiteral)
+	p.registerPrefix(LBRACE, p.parseClosureLiteral)
+
+	p.infixParseFns = make(map[TokenType]infixParseFn)
+	for t := range precedences {
+		p.registerInfix(t, p.parseInfixExpression)
+	}
+
 	p.nextToken()
 	p.nextToken()
 	return p
 }
 
+func (p *Parser) registerPrefix(tokenType TokenType, fn prefixParseFn) {
+	p.prefixParseFns[tokenType] = fn
+}
+
+func (p *Parser) registerInfix(tokenType TokenType, fn infixParseFn) {
+	p.infixParseFns[tokenType] = fn
+}
+
 func (p *Parser) Errors() []string {
 	return p.errors
 }
 
-func (p *Parser) peekError(t TokenType) {
-	msg := fmt.Sprintf("se esperaba que el siguiente token fuera %s, pero se obtuvo %s",
-		t, p.peekToken.Type)
+// ShowLines hace que los errores indiquen la línea aunque la entrada tenga
+// una sola, como en un fichero de configuración.
+func (p *Parser) ShowLines() {
+	p.multiline = true
+}
+
+// errorf registra un error de parsing en la línea del token actual, salvo si
+// el statement ya tiene uno.
+func (p *Parser) errorf(format string, a ...interface{}) {
+	if len(p.errors) > p.statementErrors {
+		return
+	}
+	msg := fmt.Sprintf(format, a...)
+	if p.multiline {
+		msg = fmt.Sprintf("línea %d: %s", p.curToken.Line, msg)
+	}
 	p.errors = append(p.errors, msg)
 }
 
+func (p *Parser) peekError(t TokenType) {
+	p.errorf("se esperaba que el siguiente token fuera %s, pero se obtuvo %s", t, describeToken(p.peekToken))
+}
+
+// describeToken muestra un token en un mensaje de error: su literal entre
+// comillas, o el final de la línea o de la entrada.
+func describeToken(tok Token) string {
+	switch tok.Type {
+	case NEWLINE:
+		return "fin de línea"
+	case EOF:
+		return "final de la entrada"
+	default:
+		return "'" + tok.Literal + "'"
+	}
+}
+
 func (p *Parser) nextToken() {
 	p.curToken = p.peekToken
 	p.peekToken = p.l.NextToken()
@@ -45,8 +160,17 @@ func (p *Parser) ParseProgram() *Program {
 	program.Statements = []Statement{}
 
 	for p.curToken.Type != EOF {
+		// Los saltos de línea y los ';' vacíos solo separan statements.
+		if p.curTokenIsTerminator() {
+			p.nextToken()
+			continue
+		}
+		// Un statement con errores se descarta y se sigue con el siguiente, de
+		// modo que quien quiera (ej. un rc) puede ejecutar el resto.
+		p.statementErrors = len(p.errors)
 		stmt := p.parseStatement()
-		if stmt != nil {
+		p.skipToStatementEnd(stmt, EOF)
+		if stmt != nil && len(p.errors) == p.statementErrors {
 			program.Statements = append(program.Statements, stmt)
 		}
 		p.nextToken()
@@ -55,16 +179,47 @@ func (p *Parser) ParseProgram() *Program {
 	return program
 }
 
+// skipToStatementEnd comprueba que tras un statement venga un separador o el
+// token end que cierra la lista de statements. Si no es así, registra un error
+// y descarta los tokens restantes hasta el siguiente separador.
+func (p *Parser) skipToStatementEnd(stmt Statement, end TokenType) {
+	if p.peekTokenIsTerminator() || p.peekTokenIs(end) || p.curTokenIs(AMPERSAND) {
+		return
+	}
+	// Un statement que falló al llegar al final de la línea (`let x =`) no
+	// se lleva por delante la siguiente.
+	if stmt == nil && (p.curTokenIsTerminator() || p.curTokenIs(EOF)) {
+		return
+	}
+	// Si el statement falló ya hay un error registrado; solo descartamos el resto.
+	if stmt != nil {
+		p.errorf("se esperaba el final del statement, pero se obtuvo %s", describeToken(p.peekToken))
+	}
+	for !p.peekTokenIsTerminator() && !p.peekTokenIs(end) {
+		p.nextToken()
+	}
+}
+
 func (p *Parser) parseStatement() Statement {
 	switch p.curToken.Type {
 	case LET:
 		return p.parseLetStatement()
+	case FOR:
+		return p.parseForStatement()
+	case BREAK, CONTINUE:
+		return p.parseLoopControlStatement()
+	case DEF:
+		return p.parseDefStatement()
+	case RETURN:
+		return p.parseReturnStatement()
+	case ALIAS:
+		return p.parseAliasStatement()
 	default:
 		return p.parseExpressionStatement()
 	}
 }
 
-func (p *Parser) parseLetStatement() *LetStatement {
+func (p *Parser) parseLetStatement() Statement {
 	stmt := &LetStatement{Token: p.curToken}
 
 	if !p.expectPeek(IDENT) {
@@ -79,32 +234,213 @@ func (p *Parser) parseLetStatement() *LetStatement {
 
 	p.nextToken()
 
-	stmt.Value = p.parseExpression()
+	stmt.Value = p.parsePipeline()
 
 	// Es crucial verificar si la expresión fue parseada correctamente.
 	if stmt.Value == nil {
-		msg := fmt.Sprintf("no se encontró una expresión válida después de '=' en la declaración let")
-		p.errors = append(p.errors, msg)
+		p.errorf("no se encontró una expresión válida después de '=' en la declaración let")
 		return nil
 	}
 
 	return stmt
 }
 
-func (p *Parser) parseExpressionStatement() *ExpressionStatement {
+// parseForStatement parsea `for <variable> in <iterable> { ... }`.
+func (p *Parser) parseForStatement() Statement {
+	stmt := &ForStatement{Token: p.curToken}
+
+	if !p.expectPeek(IDENT) {
+		return nil
+	}
+	stmt.Variable = &Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	if !p.expectPeek(IN) {
+		return nil
+	}
+	p.nextToken()
+	inHeader := p.inHeader
+	p.inHeader = true
+	stmt.Iterable = p.parsePipeline()
+	p.inHeader = inHeader
+	if stmt.Iterable == nil {
+		return nil
+	}
+
+	if !p.expectPeek(LBRACE) {
+		return nil
+	}
+	p.loopDepth++
+	stmt.Body = p.parseBlockStatement()
+	p.loopDepth--
+
+	return stmt
+}
+
+// parseLoopControlStatement parsea `break` y `continue`.
+func (p *Parser) parseLoopControlStatement() Statement {
+	if p.loopDepth == 0 {
+		p.errorf("'%s' solo puede usarse dentro de un bucle", p.curToken.Literal)
+		return nil
+	}
+	if p.curTokenIs(BREAK) {
+		return &BreakStatement{Token: p.curToken}
+	}
+	return &ContinueStatement{Token: p.curToken}
+}
+
+// parseDefStatement parsea `def name(a, b) { ... }`. Los paréntesis son
+// opcionales si la función no tiene parámetros: `def hola { ... }`.
+func (p *Parser) parseDefStatement() Statement {
+	stmt := &DefStatement{Token: p.curToken}
+
+	if !p.expectPeek(IDENT) {
+		return nil
+	}
+	stmt.Name = &Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	if p.peekTokenIs(LPAREN) {
+		p.nextToken()
+		stmt.Parameters = p.parseFunctionParameters()
+		if stmt.Parameters == nil {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(LBRACE) {
+		return nil
+	}
+	// Un break dentro de la función no puede salir de un bucle exterior.
+	loopDepth := p.loopDepth
+	p.loopDepth = 0
+	p.funcDepth++
+	stmt.Body = p.parseBlockStatement()
+	p.funcDepth--
+	p.loopDepth = loopDepth
+
+	return stmt
+}
+
+// parseFunctionParameters parsea `(a, b, c)` a partir del '(' actual.
+// Devuelve nil si hay un error y una lista vacía para `()`.
+func (p *Parser) parseFunctionParameters() []*Identifier {
+	identifiers := []*Identifier{}
+
+	if p.peekTokenIs(RPAREN) {
+		p.nextToken()
+		return identifiers
+	}
+
+	if !p.expectPeek(IDENT) {
+		return nil
+	}
+	identifiers = append(identifiers, &Identifier{Token: p.curToken, Value: p.curToken.Literal})
+
+	for p.peekTokenIs(COMMA) {
+		p.nextToken()
+		if !p.expectPeek(IDENT) {
+			return nil
+		}
+		identifiers = append(identifiers, &Identifier{Token: p.curToken, Value: p.curToken.Literal})
+	}
+
+	if !p.expectPeek(RPAREN) {
+		return nil
+	}
+	return identifiers
+}
+
+// parseReturnStatement parsea `return` con o sin valor.
+func (p *Parser) parseReturnStatement() Statement {
+	if p.funcDepth == 0 {
+		p.errorf("'return' solo puede usarse dentro de una función")
+		return nil
+	}
+	stmt := &ReturnStatement{Token: p.curToken}
+	if p.peekTokenIsTerminator() || p.peekTokenIs(RBRACE) {
+		return stmt
+	}
+	p.nextToken()
+	stmt.ReturnValue = p.parsePipeline()
+	if stmt.ReturnValue == nil {
+		return nil
+	}
+	return stmt
+}
+
+// parseAliasStatement parsea `alias name = comando args...`.
+func (p *Parser) parseAliasStatement() Statement {
+	stmt := &AliasStatement{Token: p.curToken}
+
+	if !p.expectPeek(IDENT) {
+		return nil
+	}
+	stmt.Name = &Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	if !p.expectPeek(ASSIGN) {
+		return nil
+	}
+	p.nextToken()
+	if !p.isCommandStartToken() {
+		p.errorf("un alias debe ser un comando, pero se obtuvo %s", describeToken(p.curToken))
+		return nil
+	}
+	// Si el comando no se pudo parsear (ej. `alias l = ls >`), el error ya está registrado.
+	cmd, ok := p.parseCommandExpression().(*CommandExpression)
+	if !ok {
+		return nil
+	}
+	stmt.Command = cmd
+
+	return stmt
+}
+
+func (p *Parser) parseExpressionStatement() Statement {
 	stmt := &ExpressionStatement{Token: p.curToken}
-	stmt.Expression = p.parseExpression()
+	stmt.Expression = p.parsePipeline()
 
 	if stmt.Expression == nil {
 		return nil
 	}
 
+	// Un '&' final lanza el statement en segundo plano y, como un ';', lo cierra.
+	if p.peekTokenIs(AMPERSAND) {
+		p.nextToken()
+		stmt.Background = true
+	}
+
 	return stmt
 }
 
-// ¡FUNCIÓN CORREGIDA!
-// Esta es la lógica principal que soluciona el bug.
-func (p *Parser) parseExpression() Expression {
+// parsePipeline parsea uno o más pipelines encadenados con `&&` y `||`, que
+// tienen la misma precedencia y se agrupan de izquierda a derecha, como en sh.
+func (p *Parser) parsePipeline() Expression {
+	left := p.parsePipe()
+
+	for p.peekTokenIs(AND) || p.peekTokenIs(OR) {
+		if left == nil {
+			return nil
+		}
+		p.nextToken()
+		expression := &LogicalExpression{Token: p.curToken, Operator: p.curToken.Literal, Left: left}
+		p.nextToken()
+		// Como con el pipe, un `&&` o `||` al final de la línea continúa en la siguiente.
+		for p.curTokenIs(NEWLINE) {
+			p.nextToken()
+		}
+		expression.Right = p.parsePipe()
+		if expression.Right == nil {
+			p.errorf("expresión vacía o inválida después de '%s'", expression.Operator)
+			return nil
+		}
+		left = expression
+	}
+
+	return left
+}
+
+// parsePipe parsea un comando o una expresión, seguidos opcionalmente de
+// un pipe y del resto del pipeline.
+func (p *Parser) parsePipe() Expression {
 	var left Expression
 
 	// Decidimos qué tipo de expresión estamos viendo.
@@ -112,23 +448,27 @@ func (p *Parser) parseExpression() Expression {
 		// Parece un comando, como `ls -l` o `curl ...`
 		left = p.parseCommandExpression()
 	} else {
-		// Es otra cosa, como un StringLiteral `"hola"` o un futuro número.
-		left = p.parsePrimaryExpression()
+		// Es otra cosa, como un StringLiteral `"hola"` o una expresión aritmética.
+		left = p.parseExpression(LOWEST)
 	}
 
 	// Después de parsear la parte izquierda, comprobamos si le sigue un pipe.
 	if p.peekTokenIs(PIPE) {
 		if left == nil {
-			p.errors = append(p.errors, "expresión inválida antes del pipe '|'")
+			p.errorf("expresión inválida antes del pipe '|'")
 			return nil
 		}
 		p.nextToken()
 		pipeToken := p.curToken
 		p.nextToken() // Avanzamos al inicio de la expresión derecha
-		right := p.parseExpression()
+		// Un pipe al final de la línea continúa el pipeline en la siguiente.
+		for p.curTokenIs(NEWLINE) {
+			p.nextToken()
+		}
+		right := p.parsePipe()
 
 		if right == nil {
-			p.errors = append(p.errors, "expresión vacía o inválida después del pipe '|'")
+			p.errorf("expresión vacía o inválida después del pipe '|'")
 			return nil
 		}
 
@@ -149,8 +489,44 @@ func (p *Parser) parseCommandExpression() Expression {
 		Args:  []Expression{},
 	}
 
-	for !p.peekTokenIs(PIPE) && !p.peekTokenIs(EOF) {
+	// Los argumentos de `where` forman un predicado. Los `>` y `<` que contiene
+	// son comparaciones; los que le siguen, redirecciones: `where .age > 30 > adults.json`.
+	if cmd.Token.Type == WHERE && !p.peekTokenIsCommandEnd() {
 		p.nextToken()
+		predicate := &PredicateExpression{Token: p.curToken}
+		if p.curTokenIsWord("-i") || p.curTokenIsWord("--ignore-case") {
+			predicate.IgnoreCase = true
+			if p.peekTokenIsCommandEnd() {
+				p.errorf("se esperaba un predicado después de '%s'", p.curToken.Literal)
+				return nil
+			}
+			p.nextToken()
+		}
+		predicate.Predicate = p.parsePredicate()
+		if predicate.Predicate == nil {
+			return nil
+		}
+		cmd.Args = append(cmd.Args, predicate)
+	}
+
+	for !p.peekTokenIsCommandEnd() {
+		p.nextToken()
+		if p.curTokenIsRedirect() {
+			redirect := p.parseRedirect()
+			if redirect == nil {
+				return nil
+			}
+			cmd.Redirects = append(cmd.Redirects, redirect)
+			continue
+		}
+		if cmd.Token.Type == WHERE {
+			p.errorf("se esperaba 'and', 'or' o el final del predicado de where, pero se obtuvo %s", describeToken(p.curToken))
+			return nil
+		}
+		// Una coma suelta separa argumentos: `select n: .name, .age`.
+		if p.curTokenIs(COMMA) {
+			continue
+		}
 		arg := p.parsePrimaryExpression()
 		if arg != nil {
 			cmd.Args = append(cmd.Args, arg)
@@ -160,28 +536,520 @@ func (p *Parser) parseCommandExpression() Expression {
 	return cmd
 }
 
+// parsePredicate parsea el predicado de `where` que empieza en el token actual:
+// comparaciones (`.campo op valor`) combinadas, de mayor a menor precedencia,
+// con `not`, `and` y `or`, y agrupadas con paréntesis.
+func (p *Parser) parsePredicate() Expression {
+	left := p.parsePredicateAnd()
+	for left != nil && p.peekTokenIsWord("or") {
+		left = p.parsePredicateInfix(left, p.parsePredicateAnd)
+	}
+	return left
+}
+
+func (p *Parser) parsePredicateAnd() Expression {
+	left := p.parsePredicateTerm()
+	for left != nil && p.peekTokenIsWord("and") {
+		left = p.parsePredicateInfix(left, p.parsePredicateTerm)
+	}
+	return left
+}
+
+// parsePredicateInfix parsea `left and ...` o `left or ...` a partir del
+// operador (siguiente token); parseRight parsea el lado derecho.
+func (p *Parser) parsePredicateInfix(left Expression, parseRight func() Expression) Expression {
+	p.nextToken()
+	expression := &InfixExpression{Token: p.curToken, Left: left, Operator: p.curToken.Literal}
+	if p.peekTokenIsCommandEnd() {
+		p.errorf("se esperaba una condición después de '%s'", expression.Operator)
+		return nil
+	}
+	p.nextToken()
+	expression.Right = parseRight()
+	if expression.Right == nil {
+		return nil
+	}
+	return expression
+}
+
+// parsePredicateTerm parsea `not término`, un predicado entre paréntesis o una
+// comparación.
+func (p *Parser) parsePredicateTerm() Expression {
+	switch {
+	case p.curTokenIsWord("not"):
+		expression := &PrefixExpression{Token: p.curToken, Operator: "not"}
+		if p.peekTokenIsCommandEnd() {
+			p.errorf("se esperaba una condición después de 'not'")
+			return nil
+		}
+		p.nextToken()
+		expression.Right = p.parsePredicateTerm()
+		if expression.Right == nil {
+			return nil
+		}
+		return expression
+	case p.curTokenIs(LPAREN):
+		p.nextToken()
+		predicate := p.parsePredicate()
+		if predicate == nil || !p.expectPeek(RPAREN) {
+			return nil
+		}
+		return predicate
+	default:
+		return p.parseComparison()
+	}
+}
+
+// parseComparison parsea una comparación de un predicado: `.campo op valor`.
+// Además de los operadores de comparación, acepta los de texto (`contains`,
+// `starts-with`, `ends-with`, `=~` y `!~`) y `in`, seguido de una lista.
+// Las comparaciones no se encadenan: tras el valor solo puede venir `and`,
+// `or`, `)` o el final del predicado.
+func (p *Parser) parseComparison() Expression {
+	left := p.parsePrimaryExpression()
+	if left == nil {
+		return nil
+	}
+	switch {
+	case p.peekTokenIs(EQ), p.peekTokenIs(NEQ), p.peekTokenIs(GT), p.peekTokenIs(LT), p.peekTokenIs(GTE), p.peekTokenIs(LTE),
+		p.peekTokenIs(MATCH), p.peekTokenIs(NOT_MATCH), p.peekTokenIs(IN),
+		p.peekTokenIsWord("contains"), p.peekTokenIsWord("starts-with"), p.peekTokenIsWord("ends-with"):
+	default:
+		p.errorf("se esperaba un operador de comparación después de '%s'", left.String())
+		return nil
+	}
+	p.nextToken()
+	expression := &InfixExpression{Token: p.curToken, Left: left, Operator: p.curToken.Literal}
+	if p.peekTokenIsCommandEnd() {
+		p.errorf("se esperaba un valor después de '%s'", expression.Operator)
+		return nil
+	}
+	p.nextToken()
+	expression.Right = p.parsePrimaryExpression()
+	if expression.Right == nil {
+		return nil
+	}
+	return expression
+}
+
+// curTokenIsWord indica si el token actual es la palabra w (ej. `and` en un
+// predicado), que fuera de ese contexto es un identificador cualquiera.
+func (p *Parser) curTokenIsWord(w string) bool {
+	return p.curToken.Type == IDENT && p.curToken.Literal == w
+}
+
+func (p *Parser) peekTokenIsWord(w string) bool {
+	return p.peekToken.Type == IDENT && p.peekToken.Literal == w
+}
+
+// curTokenIsRedirect indica si el token actual es un operador de redirección.
+func (p *Parser) curTokenIsRedirect() bool {
+	switch p.curToken.Type {
+	case GT, LT, REDIRECT_APPEND, REDIRECT_ERR, REDIRECT_ERR_APPEND, REDIRECT_ERR_OUT:
+		return true
+	default:
+		return false
+	}
+}
+
+// parseRedirect parsea una redirección a partir de su operador (token actual).
+// Todas salvo `2>&1` van seguidas del fichero.
+func (p *Parser) parseRedirect() *Redirect {
+	redirect := &Redirect{Token: p.curToken, Operator: p.curToken.Literal}
+	if p.curTokenIs(REDIRECT_ERR_OUT) {
+		return redirect
+	}
+	if p.peekTokenIsCommandEnd() {
+		p.errorf("se esperaba un fichero después de '%s'", redirect.Operator)
+		return nil
+	}
+	p.nextToken()
+	redirect.Target = p.parsePrimaryExpression()
+	if redirect.Target == nil {
+		return nil
+	}
+	return redirect
+}
+
+// peekTokenIsCommandEnd indica si el siguiente token cierra la lista de
+// argumentos de un comando: un separador, un pipe, `&&`, `||`, `&`, o el final de un grupo
+// `( )` o de un bloque. En la cabecera de un `if` o un `for`, `{` también la
+// cierra para que en `if test -f x { ... }` el cuerpo no se tome como argumento.
+func (p *Parser) peekTokenIsCommandEnd() bool {
+	return p.peekTokenIsTerminator() || p.peekTokenIs(PIPE) || p.peekTokenIs(AND) || p.peekTokenIs(OR) || p.peekTokenIs(AMPERSAND) ||
+		p.peekTokenIs(RPAREN) || (p.inHeader && p.peekTokenIs(LBRACE)) || p.peekTokenIs(RBRACE)
+}
+
 func (p *Parser) isCommandStartToken() bool {
-	return p.curToken.Type == IDENT || p.curToken.Type == GET ||
-		p.curToken.Type == WHERE || p.curToken.Type == SELECT ||
-		p.curToken.Type == CD || p.curToken.Type == VARS || p.curToken.Type == EXIT
+	return IsCommandStart(p.curToken.Type)
 }
 
 // parsePrimaryExpression parsea los componentes básicos de un comando.
 func (p *Parser) parsePrimaryExpression() Expression {
 	switch p.curToken.Type {
-	case IDENT, GET, WHERE, SELECT, INT, TRUE, FALSE, EQ, NEQ, GT, LT, GTE, LTE:
+	case IDENT:
+		if strings.Contains(p.curToken.Literal, "$") {
+			return p.parseInterpolatedString()
+		}
+		// Un nombre pegado a '(' es una llamada: `aggregate count avg(.age)`.
+		if p.peekTokenIs(LPAREN) && !p.peekToken.Spaced {
+			return p.parseCallExpression()
+		}
+		return &Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	case GET, WHERE, SELECT, LET, CD, VARS, EXIT, IF, ELSE, FOR, IN, BREAK, CONTINUE, DEF, EQ, NEQ, GT, LT, GTE, LTE, MATCH, NOT_MATCH,
+		RETURN, TRY, CATCH, ALIAS, PLUS, MINUS, ASTERISK, SLASH, PERCENT, DOTDOT, COMMA:
 		return &Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	case TRUE, FALSE:
+		return p.parseBoolean()
+	case INT:
+		return p.parseIntegerLiteral()
+	case FLOAT:
+		return p.parseFloatLiteral()
+	case LPAREN:
+		return p.parseGroupedExpression()
+	case LBRACKET:
+		return p.parseArrayLiteral()
+	case LBRACE:
+		return p.parseClosureLiteral()
 	case STRING:
+		if strings.Contains(p.curToken.Literal, "$") {
+			return p.parseInterpolatedString()
+		}
+		return &StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
+	case RAWSTRING:
 		return &StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
+	case VARIABLE:
+		return newVariable(p.curToken, p.curToken.Literal)
 	case DOT:
 		return p.parsePathExpression()
 	default:
 		// Añadimos un error si no es una expresión que conocemos.
-		p.errors = append(p.errors, fmt.Sprintf("no se pudo parsear la expresión que empieza con '%s'", p.curToken.Literal))
+		p.noExpressionError()
 		return nil
 	}
 }
 
+// noExpressionError informa de que en el token actual no empieza ninguna
+// expresión: falta (`let x =` al final de la línea) o no se reconoce.
+func (p *Parser) noExpressionError() {
+	if p.curTokenIsTerminator() || p.curTokenIs(EOF) {
+		p.errorf("se esperaba una expresión, pero se obtuvo %s", describeToken(p.curToken))
+		return
+	}
+	p.errorf("no se pudo parsear la expresión que empieza con %s", describeToken(p.curToken))
+}
+
+// parseExpression es el parser de expresiones por precedencia de operadores
+// (Pratt). Se usa cuando un statement no empieza por un comando, como en
+// `let total = $a * 2 + 1`.
+func (p *Parser) parseExpression(precedence int) Expression {
+	prefix := p.prefixParseFns[p.curToken.Type]
+	if prefix == nil {
+		p.noExpressionError()
+		return nil
+	}
+	leftExp := prefix()
+
+	for !p.peekTokenIsTerminator() && precedence < p.peekPrecedence() {
+		infix := p.infixParseFns[p.peekToken.Type]
+		if infix == nil {
+			return leftExp
+		}
+		p.nextToken()
+		leftExp = infix(leftExp)
+	}
+
+	return leftExp
+}
+
+func (p *Parser) parseIntegerLiteral() Expression {
+	value, err := strconv.ParseInt(p.curToken.Literal, 10, 64)
+	if err != nil {
+		p.errorf("no se pudo parsear %q como entero", p.curToken.Literal)
+		return nil
+	}
+	return &IntegerLiteral{Token: p.curToken, Value: value}
+}
+
+func (p *Parser) parseFloatLiteral() Expression {
+	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
+	if err != nil {
+		p.errorf("no se pudo parsear %q como decimal", p.curToken.Literal)
+		return nil
+	}
+	return &FloatLiteral{Token: p.curToken, Value: value}
+}
+
+func (p *Parser) parsePrefixExpression() Expression {
+	expression := &PrefixExpression{Token: p.curToken, Operator: p.curToken.Literal}
+	p.nextToken()
+	expression.Right = p.parseExpression(PREFIX)
+	if expression.Right == nil {
+		return nil
+	}
+	return expression
+}
+
+func (p *Parser) parseInfixExpression(left Expression) Expression {
+	expression := &InfixExpression{Token: p.curToken, Operator: p.curToken.Literal, Left: left}
+	precedence := p.curPrecedence()
+	p.nextToken()
+	expression.Right = p.parseExpression(precedence)
+	if left == nil || expression.Right == nil {
+		return nil
+	}
+	return expression
+}
+
+func (p *Parser) parseBoolean() Expression {
+	return &Boolean{Token: p.curToken, Value: p.curTokenIs(TRUE)}
+}
+
+// parseIfExpression parsea `if <cond> { ... } [else if <cond> { ... }] [else { ... }]`.
+// La condición puede ser una comparación, un comando o cualquier expresión.
+func (p *Parser) parseIfExpression() Expression {
+	expression := &IfExpression{Token: p.curToken}
+
+	p.nextToken()
+	inHeader := p.inHeader
+	p.inHeader = true
+	expression.Condition = p.parsePipeline()
+	p.inHeader = inHeader
+	if expression.Condition == nil {
+		return nil
+	}
+
+	if !p.expectPeek(LBRACE) {
+		return nil
+	}
+	expression.Consequence = p.parseBlockStatement()
+
+	if p.peekTokenIs(ELSE) {
+		p.nextToken()
+		if p.peekTokenIs(IF) {
+			p.nextToken()
+			elseToken := p.curToken
+			nested := p.parseIfExpression()
+			if nested == nil {
+				return nil
+			}
+			expression.Alternative = &BlockStatement{
+				Token:      elseToken,
+				Statements: []Statement{&ExpressionStatement{Token: elseToken, Expression: nested}},
+			}
+		} else {
+			if !p.expectPeek(LBRACE) {
+				return nil
+			}
+			expression.Alternative = p.parseBlockStatement()
+		}
+	}
+
+	return expression
+}
+
+// parseTryExpression parsea `try { ... } [catch [err] { ... }]`.
+func (p *Parser) parseTryExpression() Expression {
+	expression := &TryExpression{Token: p.curToken}
+
+	if !p.expectPeek(LBRACE) {
+		return nil
+	}
+	expression.Block = p.parseBlockStatement()
+
+	if p.peekTokenIs(CATCH) {
+		p.nextToken()
+		if p.peekTokenIs(IDENT) {
+			p.nextToken()
+			expression.CatchVariable = &Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		}
+		if !p.expectPeek(LBRACE) {
+			return nil
+		}
+		expression.CatchBlock = p.parseBlockStatement()
+	}
+
+	return expression
+}
+
+// parseBlockStatement parsea los statements entre '{' (token actual) y '}'.
+func (p *Parser) parseBlockStatement() *BlockStatement {
+	block := &BlockStatement{Token: p.curToken}
+	block.Statements = []Statement{}
+
+	p.nextToken()
+	for !p.curTokenIs(RBRACE) && !p.curTokenIs(EOF) {
+		if p.curTokenIsTerminator() {
+			p.nextToken()
+			continue
+		}
+		stmt := p.parseStatement()
+		if stmt != nil {
+			block.Statements = append(block.Statements, stmt)
+		}
+		p.skipToStatementEnd(stmt, RBRACE)
+		p.nextToken()
+	}
+
+	if !p.curTokenIs(RBRACE) {
+		p.errorf("bloque sin cerrar: se esperaba '}'")
+	}
+	return block
+}
+
+// parseGroupedExpression parsea `( ... )`, que puede contener un pipeline
+// completo: `(cat file.txt | get .name)`.
+func (p *Parser) parseGroupedExpression() Expression {
+	p.nextToken()
+	// Dentro de los paréntesis, un `{` vuelve a ser un bloque como argumento.
+	inHeader := p.inHeader
+	p.inHeader = false
+	exp := p.parsePipeline()
+	p.inHeader = inHeader
+	if !p.expectPeek(RPAREN) {
+		return nil
+	}
+	return exp
+}
+
+// parseClosureLiteral parsea un bloque usado como valor, con parámetros
+// opcionales: `{ $it + 1 }`, `{ |acc, x| $acc + $x }`. `{}` es el registro vacío.
+func (p *Parser) parseClosureLiteral() Expression {
+	if p.peekTokenIs(RBRACE) {
+		record := &EmptyRecordLiteral{Token: p.curToken}
+		p.nextToken()
+		return record
+	}
+	closure := &ClosureLiteral{Token: p.curToken, Parameters: []*Identifier{}}
+	if p.peekTokenIs(OR) {
+		p.nextToken() // `||`: sin parámetros
+	} else if p.peekTokenIs(PIPE) {
+		p.nextToken()
+		for !p.peekTokenIs(PIPE) {
+			if len(closure.Parameters) > 0 && !p.expectPeek(COMMA) {
+				return nil
+			}
+			if !p.expectPeek(IDENT) {
+				return nil
+			}
+			closure.Parameters = append(closure.Parameters, &Identifier{Token: p.curToken, Value: p.curToken.Literal})
+		}
+		p.nextToken()
+	}
+	// Como en las funciones, break no sale de un bucle exterior y return
+	// termina el bloque.
+	inHeader, loopDepth := p.inHeader, p.loopDepth
+	p.inHeader, p.loopDepth = false, 0
+	p.funcDepth++
+	closure.Body = p.parseBlockStatement()
+	p.funcDepth--
+	p.inHeader, p.loopDepth = inHeader, loopDepth
+	closure.Body.Token = closure.Token
+	return closure
+}
+
+// parseCallExpression parsea una llamada `nombre(arg, ...)` que empieza en el
+// nombre actual.
+func (p *Parser) parseCallExpression() Expression {
+	call := &CallExpression{Token: p.curToken, Function: &Identifier{Token: p.curToken, Value: p.curToken.Literal}}
+	p.nextToken() // el '('
+	for !p.peekTokenIs(RPAREN) {
+		if len(call.Arguments) > 0 && !p.expectPeek(COMMA) {
+			return nil
+		}
+		p.nextToken()
+		arg := p.parsePrimaryExpression()
+		if arg == nil {
+			return nil
+		}
+		call.Arguments = append(call.Arguments, arg)
+	}
+	p.nextToken() // el ')'
+	return call
+}
+
+// parseArrayLiteral parsea una lista de valores separados por comas: `[1, 2]`.
+func (p *Parser) parseArrayLiteral() Expression {
+	array := &ArrayLiteral{Token: p.curToken, Elements: []Expression{}}
+	if p.peekTokenIs(RBRACKET) {
+		p.nextToken()
+		return array
+	}
+	for {
+		p.nextToken()
+		element := p.parsePrimaryExpression()
+		if element == nil {
+			return nil
+		}
+		array.Elements = append(array.Elements, element)
+		if !p.peekTokenIs(COMMA) {
+			break
+		}
+		p.nextToken()
+	}
+	if !p.expectPeek(RBRACKET) {
+		return nil
+	}
+	return array
+}
+
+func (p *Parser) peekPrecedence() int {
+	if p, ok := precedences[p.peekToken.Type]; ok {
+		return p
+	}
+	return LOWEST
+}
+
+func (p *Parser) curPrecedence() int {
+	if p, ok := precedences[p.curToken.Type]; ok {
+		return p
+	}
+	return LOWEST
+}
+
+// parseInterpolatedString divide el literal del token actual en trozos de texto
+// y referencias a variables. `\$` produce un '$' literal.
+func (p *Parser) parseInterpolatedString() Expression {
+	tok := p.curToken
+	node := &InterpolatedString{Token: tok}
+	l := NewLexer(tok.Literal)
+
+	var text strings.Builder
+	flush := func() {
+		if text.Len() > 0 {
+			node.Parts = append(node.Parts, &StringLiteral{Token: tok, Value: text.String()})
+			text.Reset()
+		}
+	}
+
+	for l.ch != 0 {
+		switch {
+		case l.ch == '\\' && l.peekChar() == '$':
+			l.readChar()
+			text.WriteByte('$')
+			l.readChar()
+		case l.ch == '$' && (isVariableChar(l.peekChar()) || l.peekChar() == '{' || l.peekChar() == '?'):
+			flush()
+			node.Parts = append(node.Parts, newVariable(tok, l.readVariable()))
+		default:
+			text.WriteString(l.input[l.position:l.readPosition])
+			l.readChar()
+		}
+	}
+	flush()
+
+	return node
+}
+
+// newVariable crea un nodo Variable a partir de una referencia como
+// "user.name" o "xs[0]": la ruta empieza en el primer '.' o '['.
+func newVariable(tok Token, ref string) *Variable {
+	if i := strings.IndexAny(ref, ".["); i >= 0 {
+		return &Variable{Token: tok, Name: ref[:i], Path: ref[i:]}
+	}
+	return &Variable{Token: tok, Name: ref}
+}
+
 func (p *Parser) parsePathExpression() Expression {
 	startToken := p.curToken
 	var path strings.Builder
@@ -202,6 +1070,16 @@ func (p *Parser) parsePathExpression() Expression {
 	return &Identifier{Token: startToken, Value: path.String()}
 }
 
+// curTokenIsTerminator indica si el token actual cierra un statement.
+func (p *Parser) curTokenIsTerminator() bool {
+	return p.curTokenIs(NEWLINE) || p.curTokenIs(SEMICOLON)
+}
+
+// peekTokenIsTerminator indica si el siguiente token cierra el statement actual.
+func (p *Parser) peekTokenIsTerminator() bool {
+	return p.peekTokenIs(NEWLINE) || p.peekTokenIs(SEMICOLON) || p.peekTokenIs(EOF)
+}
+
 func (p *Parser) curTokenIs(t TokenType) bool {
 	return p.curToken.Type == t
 }
@@ -0,0 +1,95 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+)
+
+// parse parsea input y devuelve el programa y los errores de parsing.
+func parse(input string) (*Program, []string) {
+	p := NewParser(NewLexer(input))
+	program := p.ParseProgram()
+	return program, p.Errors()
+}
+
+func TestParseProgram(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`ls -l | get .name`, `(ls -l | get .name)`},
+		{`let x = 1 + 2 * 3`, `let x = (1 + (2 * 3))`},
+		{`let y = (1 + 2) * -3`, `let y = ((1 + 2) * -3)`},
+		{`where .age > 30 and .name == "a"`, `where ((.age > 30) and (.name == "a"))`},
+		{`where not (.a == 1 or .b == 2)`, `where (not ((.a == 1) or (.b == 2)))`},
+		{`where -i .name contains ali`, `where -i (.name contains ali)`},
+		{`where .tag in [a, b]`, `where (.tag in [a, b])`},
+		{`select n: .name, .age`, `select n: .name .age`},
+		{`def f(a, b) { $a }`, `def f(a, b) { $a }`},
+		{`alias ll = ls -l`, `alias ll = ls -l`},
+		{`ls > out.txt 2>&1`, `ls > out.txt 2>&1`},
+		{`true && echo si || echo no`, `true && echo si || echo no`},
+		{`[1, 2] | each { |x, i| $x }`, `([1, 2] | each { |x, i| $x })`},
+		{`if $x > 1 { a } else { b }`, `if ($x > 1) { a } else { b }`},
+		{`for i in 1..3 { echo $i }`, `for i in (1 .. 3) { echo $i }`},
+		{`aggregate count avg(.age)`, `aggregate count avg(.age)`},
+		{`sort-by .name, .age`, `sort-by .name .age`},
+		{`echo f(a, b) g (c)`, `echo f(a, b) g c`},
+		{`reduce -f {} { |acc| $acc }`, `reduce -f {} { |acc| $acc }`},
+		{`let e = {}`, `let e = {}`},
+	}
+	for _, tt := range tests {
+		program, errs := parse(tt.input)
+		if len(errs) > 0 {
+			t.Errorf("%q: errores inesperados: %v", tt.input, errs)
+			continue
+		}
+		if got := program.String(); got != tt.want {
+			t.Errorf("%q: se obtuvo %q, se esperaba %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`alias l = ls >`, "se esperaba un fichero después de '>'"},
+		{`alias w = where .a`, "se esperaba un operador de comparación después de '.a'"},
+		{`alias x = 1`, "un alias debe ser un comando"},
+		{`ls | `, "se esperaba una expresión, pero se obtuvo final de la entrada"},
+		{"let x = \nlet y = 2", "línea 1: se esperaba una expresión, pero se obtuvo fin de línea"},
+		{`let x = (1 + 2`, "se esperaba que el siguiente token fuera ), pero se obtuvo final de la entrada"},
+		{`where .a > 1 .b`, "se esperaba 'and', 'or' o el final del predicado"},
+		{`where .a > 1 and`, "se esperaba una condición después de 'and'"},
+		{`break`, "'break' solo puede usarse dentro de un bucle"},
+		{`return 1`, "'return' solo puede usarse dentro de una función"},
+		{`if true { echo`, "bloque sin cerrar"},
+		{`let = 1`, "se esperaba que el siguiente token fuera IDENT"},
+	}
+	for _, tt := range tests {
+		_, errs := parse(tt.input)
+		if len(errs) == 0 {
+			t.Errorf("%q: se esperaba un error de parsing", tt.input)
+			continue
+		}
+		if !strings.Contains(strings.Join(errs, "\n"), tt.want) {
+			t.Errorf("%q: errores %q, se esperaba uno con %q", tt.input, errs, tt.want)
+		}
+	}
+}
+
+func TestParseRecovery(t *testing.T) {
+	program, errs := parse("let a = 1\nlet b = \nalias ll = ls -l\nwhere .a > 1 .b\necho fin")
+	want := []string{
+		"línea 2: se esperaba una expresión, pero se obtuvo fin de línea",
+		"línea 4: se esperaba 'and', 'or' o el final del predicado de where, pero se obtuvo '.b'",
+	}
+	if strings.Join(errs, "\n") != strings.Join(want, "\n") {
+		t.Errorf("errores %q, se esperaba %q", errs, want)
+	}
+	if got := program.String(); got != "let a = 1alias ll = ls -lecho fin" {
+		t.Errorf("se obtuvo el programa %q", got)
+	}
+}
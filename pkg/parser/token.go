@@ -3,81 +3,145 @@ package parser
 // TokenType es un alias para string, usado para representar el tipo de token.
 type TokenType string
 
-// Token representa un token léxico, con su tipo y el literal original.
+// Token representa un token léxico, con su tipo, el literal original y la
+// línea de la entrada en la que empieza.
 type Token struct {
 	Type    TokenType
 	Literal string
+	Line    int
+	Spaced  bool // había espacios antes del token
 }
 
 const (
 	// Tipos de tokens especiales
 	ILLEGAL TokenType = "ILLEGAL" // Carácter o secuencia no reconocida
 	EOF     TokenType = "EOF"     // Fin de archivo / entrada
+	NEWLINE TokenType = "NEWLINE" // Salto de línea (separa statements en scripts)
 
 	// Identificadores y literales
-	IDENT  TokenType = "IDENT"  // Nombres de comandos, variables, etc. (ej. ls, my_var)
-	INT    TokenType = "INT"    // Números enteros (ej. 123)
-	STRING TokenType = "STRING" // Cadenas de texto (ej. "hello world", 'otra cadena')
+	IDENT     TokenType = "IDENT"     // Nombres de comandos, variables, etc. (ej. ls, my_var)
+	INT       TokenType = "INT"       // Números enteros (ej. 123)
+	FLOAT     TokenType = "FLOAT"     // Números decimales (ej. 3.14)
+	STRING    TokenType = "STRING"    // Cadenas de texto con expansión de variables (ej. "hello $user")
+	RAWSTRING TokenType = "RAWSTRING" // Cadenas literales, sin expansión (ej. 'otra cadena')
+	VARIABLE  TokenType = "VARIABLE"  // Referencias a variables (ej. $user, ${user}, $user.name, $?)
 
 	// Operadores
-	ASSIGN   TokenType = "="   // Asignación (let x = 10)
-	PIPE     TokenType = "|"   // Pipe de comandos
-	EQ       TokenType = "=="  // Igualdad
-	NEQ      TokenType = "!="  // Desigualdad
-	GT       TokenType = ">"   // Mayor que
-	LT       TokenType = "<"   // Menor que
-	GTE      TokenType = ">="  // Mayor o igual que
-	LTE      TokenType = "<="  // Menor o igual que
-	DOT      TokenType = "."   // Acceso a campo (ej. .data.name)
+	ASSIGN    TokenType = "="  // Asignación (let x = 10)
+	PIPE      TokenType = "|"  // Pipe de comandos
+	AND       TokenType = "&&" // Ejecuta el siguiente pipeline si el anterior tuvo éxito
+	OR        TokenType = "||" // Ejecuta el siguiente pipeline si el anterior falló
+	AMPERSAND TokenType = "&"  // Ejecuta el statement en segundo plano
+	EQ        TokenType = "==" // Igualdad
+	NEQ       TokenType = "!=" // Desigualdad
+	GT        TokenType = ">"  // Mayor que
+	LT        TokenType = "<"  // Menor que
+	GTE       TokenType = ">=" // Mayor o igual que
+	LTE       TokenType = "<=" // Menor o igual que
+	MATCH     TokenType = "=~" // Coincide con una expresión regular (en `where`)
+	NOT_MATCH TokenType = "!~" // No coincide con una expresión regular
+
+	// Redirecciones (`>` y `<` redirigen stdout y stdin fuera de las comparaciones)
+	REDIRECT_APPEND     TokenType = ">>"   // Añade stdout al final de un fichero
+	REDIRECT_ERR        TokenType = "2>"   // Redirige stderr a un fichero
+	REDIRECT_ERR_APPEND TokenType = "2>>"  // Añade stderr al final de un fichero
+	REDIRECT_ERR_OUT    TokenType = "2>&1" // Une stderr con stdout
+	DOT                 TokenType = "."    // Acceso a campo (ej. .data.name)
+	DOTDOT              TokenType = ".."   // Rango (ej. 1..10)
+	PLUS                TokenType = "+"    // Suma
+	MINUS               TokenType = "-"    // Resta o negación
+	ASTERISK            TokenType = "*"    // Multiplicación
+	SLASH               TokenType = "/"    // División
+	PERCENT             TokenType = "%"    // Módulo
 
 	// Delimitadores
-	COMMA    TokenType = ","   // Coma (para separar argumentos, etc.)
-	SEMICOLON TokenType = ";"  // Punto y coma (para separar statements, si se implementa)
-	LPAREN   TokenType = "("   // Paréntesis izquierdo
-	RPAREN   TokenType = ")"   // Paréntesis derecho
-	LBRACE   TokenType = "{"   // Llave izquierda (para bloques de código)
-	RBRACE   TokenType = "}"   // Llave derecha
-	LBRACKET TokenType = "["   // Corchete izquierdo (para arrays o indexing)
-	RBRACKET TokenType = "]"   // Corchete derecho
+	COMMA     TokenType = "," // Coma (para separar argumentos, etc.)
+	SEMICOLON TokenType = ";" // Punto y coma (para separar statements, si se implementa)
+	LPAREN    TokenType = "(" // Paréntesis izquierdo
+	RPAREN    TokenType = ")" // Paréntesis derecho
+	LBRACE    TokenType = "{" // Llave izquierda (para bloques de código)
+	RBRACE    TokenType = "}" // Llave derecha
+	LBRACKET  TokenType = "[" // Corchete izquierdo (para arrays o indexing)
+	RBRACKET  TokenType = "]" // Corchete derecho
 
 	// Palabras clave
-	LET    TokenType = "LET"    // 'let' keyword
-	CD     TokenType = "CD"     // 'cd' keyword
-	VARS   TokenType = "VARS"   // 'vars' keyword
-	EXIT   TokenType = "EXIT"   // 'exit' keyword
-	GET    TokenType = "GET"    // 'get' built-in
-	WHERE  TokenType = "WHERE"  // 'where' built-in
-	SELECT TokenType = "SELECT" // 'select' built-in
-	IF     TokenType = "IF"     // 'if' keyword
-	ELSE   TokenType = "ELSE"   // 'else' keyword
-	FOR    TokenType = "FOR"    // 'for' keyword
-	DEF    TokenType = "DEF"    // 'def' keyword (para definir funciones)
-	TRUE   TokenType = "TRUE"   // 'true' boolean literal
-	FALSE  TokenType = "FALSE"  // 'false' boolean literal
+	LET      TokenType = "LET"      // 'let' keyword
+	CD       TokenType = "CD"       // 'cd' keyword
+	VARS     TokenType = "VARS"     // 'vars' keyword
+	EXIT     TokenType = "EXIT"     // 'exit' keyword
+	GET      TokenType = "GET"      // 'get' built-in
+	WHERE    TokenType = "WHERE"    // 'where' built-in
+	SELECT   TokenType = "SELECT"   // 'select' built-in
+	IF       TokenType = "IF"       // 'if' keyword
+	ELSE     TokenType = "ELSE"     // 'else' keyword
+	FOR      TokenType = "FOR"      // 'for' keyword
+	IN       TokenType = "IN"       // 'in' keyword (for x in ...)
+	BREAK    TokenType = "BREAK"    // 'break' keyword
+	CONTINUE TokenType = "CONTINUE" // 'continue' keyword
+	DEF      TokenType = "DEF"      // 'def' keyword (para definir funciones)
+	RETURN   TokenType = "RETURN"   // 'return' keyword
+	TRY      TokenType = "TRY"      // 'try' keyword
+	CATCH    TokenType = "CATCH"    // 'catch' keyword
+	ALIAS    TokenType = "ALIAS"    // 'alias' keyword
+	TRUE     TokenType = "TRUE"     // 'true' boolean literal
+	FALSE    TokenType = "FALSE"    // 'false' boolean literal
 )
 
 // keywords es un mapa para buscar si una cadena es una palabra clave.
 var keywords = map[string]TokenType{
-	"let":    LET,
-	"cd":     CD,
-	"vars":   VARS,
-	"exit":   EXIT,
-	"get":    GET,
-	"where":  WHERE,
-	"select": SELECT,
-	"if":     IF,
-	"else":   ELSE,
-	"for":    FOR,
-	"def":    DEF,
-	"true":   TRUE,
-	"false":  FALSE,
+	"let":      LET,
+	"cd":       CD,
+	"vars":     VARS,
+	"exit":     EXIT,
+	"get":      GET,
+	"where":    WHERE,
+	"select":   SELECT,
+	"if":       IF,
+	"else":     ELSE,
+	"for":      FOR,
+	"in":       IN,
+	"break":    BREAK,
+	"continue": CONTINUE,
+	"def":      DEF,
+	"return":   RETURN,
+	"try":      TRY,
+	"catch":    CATCH,
+	"alias":    ALIAS,
+	"true":     TRUE,
+	"false":    FALSE,
+}
+
+// operators es un mapa de las palabras formadas solo por un operador aritmético.
+// Como `-l` o `/tmp` son argumentos válidos, un operador solo se reconoce
+// cuando aparece separado por espacios (ej. `$a * 2 + 1`) o, en una expresión,
+// pegado a un operando (ver Lexer.atOperator).
+var operators = map[string]TokenType{
+	"+": PLUS,
+	"-": MINUS,
+	"*": ASTERISK,
+	"/": SLASH,
+	"%": PERCENT,
+}
+
+// IsCommandStart indica si un statement que empieza por un token de tipo t
+// es un comando (`ls -l`, `get .name`) y no una expresión.
+func IsCommandStart(t TokenType) bool {
+	switch t {
+	case IDENT, GET, WHERE, SELECT, CD, VARS, EXIT:
+		return true
+	default:
+		return false
+	}
 }
 
-// LookupIdent verifica si el identificador dado es una palabra clave
-// o un identificador de usuario.
+// LookupIdent verifica si el identificador dado es una palabra clave,
+// un operador o un identificador de usuario.
 func LookupIdent(ident string) TokenType {
 	if tok, ok := keywords[ident]; ok {
 		return tok
 	}
+	if tok, ok := operators[ident]; ok {
+		return tok
+	}
 	return IDENT
 }
@@ -81,3 +81,9 @@ func (nr *NshReadline) Close() error {
 func (nr *NshReadline) AddHistory(line string) {
 	nr.instance.SaveHistory(line)
 }
+
+// IsTerminal indica si el fichero dado está conectado a una terminal.
+// Se usa para decidir entre el REPL interactivo y el modo script.
+func IsTerminal(f *os.File) bool {
+	return readline.IsTerminal(int(f.Fd()))
+}
@@ -1,11 +1,13 @@
 package shell
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"github.com/soyunomas/nxsh/pkg/evaluator"
 	"github.com/soyunomas/nxsh/pkg/parser"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
 )
 
@@ -20,9 +22,26 @@ type Shell struct {
 	// Reemplazamos el antiguo mapa de strings por el nuevo Environment del evaluador.
 	environment *evaluator.Environment
 	lineReader  LineReader
+
+	// loadConfig indica si se cargan los ficheros de configuración (rcFiles)
+	// antes del primer prompt.
+	loadConfig bool
+
+	// En modo script no hay lineReader: se ejecuta el programa y se termina.
+	scriptName string
+	script     string
+
+	// signals recibe las señales que cancelan la evaluación (ver interruptContext).
+	signals chan os.Signal
+
+	// exited indica que se ejecutó `exit`; status es el código con el que termina.
+	exited bool
+	status int
 }
 
-func New() *Shell {
+// New crea la shell interactiva. Si loadConfig es true, Run evalúa
+// $XDG_CONFIG_HOME/nxsh/config.nxsh y ~/.nxshrc antes del primer prompt.
+func New(loadConfig bool) *Shell {
 	lr, err := NewLineReader()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error inicializando el lector de línea: %v\n", err)
@@ -31,16 +50,50 @@ func New() *Shell {
 
 	return &Shell{
 		// Creamos un único entorno que persistirá durante toda la sesión.
-		environment: evaluator.NewEnvironment(),
+		environment: evaluator.NewSessionEnvironment(),
 		lineReader:  lr,
+		loadConfig:  loadConfig,
+	}
+}
+
+// NewScript crea una shell no interactiva que ejecuta el programa source.
+// name identifica el origen del programa en los mensajes de error
+// (la ruta del script, "-c" o "<stdin>").
+func NewScript(name, source string) *Shell {
+	return &Shell{
+		environment: evaluator.NewSessionEnvironment(),
+		scriptName:  name,
+		script:      source,
 	}
 }
 
-func (s *Shell) Run() {
+// Run ejecuta la shell y devuelve el código de salida del proceso.
+func (s *Shell) Run() int {
+	// nxsh atiende Ctrl-C en lugar de terminar: se cancela la evaluación en curso.
+	s.signals = make(chan os.Signal, 1)
+	signal.Notify(s.signals, append(cancelSignals, ignoredSignals...)...)
+	defer signal.Stop(s.signals)
+
+	if s.lineReader == nil {
+		return s.runScript()
+	}
+
 	fmt.Println("Bienvenido a Nexus Shell (nxsh) v1.0.0-rc1.")
 	defer s.lineReader.Close()
+	evaluator.EnableJobControl(os.Stdin)
+
+	if s.loadConfig {
+		s.runConfig()
+		if s.exited {
+			return s.status
+		}
+	}
 
 	for {
+		// Como en bash, avisamos de los trabajos en segundo plano que han terminado.
+		for _, notice := range evaluator.FinishedJobs() {
+			fmt.Println(notice)
+		}
 		prompt := s.getPrompt()
 		line, err := s.lineReader.ReadLine(prompt)
 		if err != nil {
@@ -52,18 +105,80 @@ func (s *Shell) Run() {
 			continue
 		}
 
-		// *** LA CORRECCIÓN ESTÁ AQUÍ ***
-		// Se compara con la cadena de texto "exit", no con una variable.
-		if trimmedLine == "exit" {
-			break
+		s.lineReader.AddHistory(trimmedLine)
+		if status := s.eval(trimmedLine); s.exited {
+			return status
 		}
+	}
+	return 0
+}
 
-		s.lineReader.AddHistory(trimmedLine)
-		s.eval(trimmedLine)
+// runScript ejecuta el programa de una shell creada con NewScript y devuelve
+// su código de salida (ver evalSource).
+func (s *Shell) runScript() int {
+	return s.eval(s.script)
+}
+
+// rcFiles devuelve los ficheros de configuración que se cargan al arrancar,
+// en el orden en que se evalúan.
+func rcFiles() []string {
+	var files []string
+	home, _ := os.UserHomeDir()
+	configDir := os.Getenv("XDG_CONFIG_HOME")
+	if configDir == "" && home != "" {
+		configDir = filepath.Join(home, ".config")
+	}
+	if configDir != "" {
+		files = append(files, filepath.Join(configDir, "nxsh", "config.nxsh"))
+	}
+	if home != "" {
+		files = append(files, filepath.Join(home, ".nxshrc"))
+	}
+	return files
+}
+
+// runConfig evalúa los ficheros de configuración existentes en el entorno de
+// la sesión, de modo que sus variables, funciones y alias quedan disponibles.
+// Un error en un statement, también de parsing, se informa y se sigue con el
+// siguiente.
+func (s *Shell) runConfig() {
+	home, _ := os.UserHomeDir()
+	for _, path := range rcFiles() {
+		source, err := os.ReadFile(path)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				fmt.Fprintf(os.Stderr, "nxsh: %v\n", err)
+			}
+			continue
+		}
+		name := path
+		if home != "" && strings.HasPrefix(path, home+string(filepath.Separator)) {
+			name = "~" + strings.TrimPrefix(path, home)
+		}
+		ctx, stop := s.interruptContext()
+		s.evalSource(ctx, name, string(source), true)
+		stop()
+		if s.exited {
+			return
+		}
 	}
 }
 
+// getPrompt devuelve el prompt del REPL. Si el usuario ha definido `prompt`
+// (una variable o una función, normalmente en ~/.nxshrc), se usa su valor.
 func (s *Shell) getPrompt() string {
+	if _, ok := s.environment.Get("prompt"); ok {
+		cmd := &parser.CommandExpression{Name: &parser.Identifier{Value: "prompt"}}
+		ctx, stop := s.interruptContext()
+		result := evaluator.Eval(ctx, cmd, s.environment)
+		stop()
+		if err, ok := result.(*evaluator.Error); ok {
+			fmt.Fprintln(os.Stderr, "prompt:", err.Inspect())
+		} else {
+			return result.Inspect()
+		}
+	}
+
 	wd, err := os.Getwd()
 	if err != nil {
 		return "nxsh > "
@@ -75,39 +190,122 @@ func (s *Shell) getPrompt() string {
 	return fmt.Sprintf("%s%s%s %s%s%s ", colorCyan, wd, colorReset, colorGreen, "nxsh >", colorReset)
 }
 
-func (s *Shell) eval(line string) {
-	l := parser.NewLexer(line)
+// eval parsea y evalúa el código dado, imprimiendo el resultado de cada
+// statement, y devuelve su código de salida (ver evalSource).
+func (s *Shell) eval(source string) int {
+	ctx, stop := s.interruptContext()
+	defer stop()
+	return s.evalSource(ctx, s.scriptName, source, false)
+}
+
+// interruptContext devuelve un contexto que se cancela al pulsar Ctrl-C (ver
+// cancelSignals), y la función que deja de vigilar las señales.
+func (s *Shell) interruptContext() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if s.signals == nil {
+		return ctx, cancel
+	}
+	// Descartamos las señales que llegaron mientras no se evaluaba nada.
+	for len(s.signals) > 0 {
+		<-s.signals
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case sig := <-s.signals:
+				for _, cancelSignal := range cancelSignals {
+					if sig == cancelSignal {
+						cancel()
+					}
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ctx, func() {
+		cancel()
+		<-done
+	}
+}
+
+// evalSource parsea y evalúa source, que viene de name (un script, un fichero
+// de configuración, o "" en el REPL). En los errores de un source con varias
+// líneas se indica la línea. Como en sh, un error se informa y se continúa con
+// el siguiente statement, salvo si se interrumpió con Ctrl-C. Devuelve el
+// código de salida del último statement: el de su error o 0, de modo que un
+// error ya tratado (por un `try` o la condición de un `if`) no cuenta. Un
+// error de parsing devuelve 1 sin ejecutar nada, salvo en un fichero de
+// configuración (config), donde se ejecutan los statements sin errores y los
+// errores indican siempre la línea. Un error que ya se escribió en el destino
+// de `2>` no se repite en la terminal. `exit` detiene la evaluación y marca la
+// shell como terminada.
+func (s *Shell) evalSource(ctx context.Context, name, source string, config bool) int {
+	prefix := ""
+	if name != "" {
+		prefix = name + ": "
+	}
+
+	l := parser.NewLexer(source)
 	p := parser.NewParser(l)
+	if config {
+		p.ShowLines()
+	}
 	program := p.ParseProgram()
 
 	if len(p.Errors()) != 0 {
 		for _, msg := range p.Errors() {
-			fmt.Fprintln(os.Stderr, "Error de parsing:", msg)
+			fmt.Fprintf(os.Stderr, "%sError de parsing: %s\n", prefix, msg)
+		}
+		if !config {
+			return 1
 		}
-		return
 	}
 
-	// El evaluador ahora necesita el entorno del shell para operar.
-	evaluated := evaluator.Eval(program, s.environment)
-
-	// El evaluador devuelve NULL para 'let', no debemos imprimir nada en ese caso.
-	if evaluated != nil && evaluated.Type() != evaluator.NULL_OBJ {
-		switch obj := evaluated.(type) {
-		case *evaluator.Error:
-			fmt.Fprintln(os.Stderr, obj.Inspect())
-		case *evaluator.String:
-			fmt.Print(obj.Inspect())
-			if !strings.HasSuffix(obj.Inspect(), "\n") {
-				fmt.Println()
+	multiline := config || strings.Contains(strings.TrimRight(source, "\n"), "\n")
+	status := 0
+	// Evaluamos statement a statement para que cada uno muestre su salida,
+	// como si se hubieran escrito en líneas separadas del REPL.
+	for _, statement := range program.Statements {
+		// Los comandos externos escriben directamente en la terminal mientras
+		// se ejecutan; el resto de valores se imprimen al terminar.
+		evaluated := evaluator.EvalStatement(ctx, statement, s.environment, os.Stdout)
+		status = evaluator.ExitStatus(evaluated)
+		if err, isErr := evaluated.(*evaluator.Error); isErr {
+			if err.Exit {
+				s.exited, s.status = true, status
+				return status
+			}
+			switch {
+			case err.Reported:
+				// Ya se escribió en el destino de `2>`.
+			case multiline:
+				fmt.Fprintf(os.Stderr, "%slínea %d: %s\n", prefix, parser.StatementLine(statement), err.Inspect())
+			default:
+				fmt.Fprintf(os.Stderr, "%s%s\n", prefix, err.Inspect())
 			}
-		case *evaluator.Json:
-			encoder := json.NewEncoder(os.Stdout)
-			encoder.SetIndent("", "  ")
-			if err := encoder.Encode(obj.Value); err != nil {
-				fmt.Fprintln(os.Stderr, "Error al formatear JSON:", err)
+			if ctx.Err() != nil {
+				return status
 			}
-		default:
-			fmt.Println(obj.Inspect())
+			continue
 		}
+		printObject(evaluated)
+	}
+	return status
+}
+
+// printObject muestra un objeto evaluado en la salida estándar
+// (o en la de errores si es un *evaluator.Error).
+func printObject(evaluated evaluator.Object) {
+	// El evaluador devuelve NULL para 'let', no debemos imprimir nada en ese caso.
+	if evaluated == nil || evaluated.Type() == evaluator.NULL_OBJ {
+		return
+	}
+	if err, ok := evaluated.(*evaluator.Error); ok {
+		fmt.Fprintln(os.Stderr, err.Inspect())
+		return
 	}
+	fmt.Print(evaluator.FormatObject(evaluated))
 }
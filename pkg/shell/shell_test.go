@@ -0,0 +1,113 @@
+package shell
+
+import (
+	"context"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestScriptExitStatus(t *testing.T) {
+	tests := []struct {
+		source string
+		want   int
+	}{
+		{`echo ok`, 0},
+		{`sh -c 'exit 1'`, 1},
+		{`sh -c 'exit 3'`, 3},
+		{"sh -c 'exit 2'\nlet failed = $? == 2\nif $failed { echo si } else { throw no }", 0},
+		{`try { throw boom } catch e { $e.message }`, 0},
+		{`if (sh -c 'exit 1') { echo si }`, 0},
+		{`throw boom`, 1},
+		{`let x = `, 1},
+		{`exit 3`, 3},
+		{"echo a\nexit\nthrow no", 0},
+		{`def f { exit 4 }; f; throw no`, 4},
+		{`try { exit 5 } catch { echo atrapado }`, 5},
+		{`exit 6 || echo no`, 6},
+		{`for i in 1..3 { if $i == 2 { exit $i } }`, 2},
+		{`exit abc`, 1},
+		{`false`, 1},
+		{"false\ntrue", 0},
+		{`true && false`, 1},
+		{``, 0},
+	}
+	for _, tt := range tests {
+		if got := NewScript("test", tt.source).runScript(); got != tt.want {
+			t.Errorf("%q: código de salida %d, se esperaba %d", tt.source, got, tt.want)
+		}
+	}
+}
+
+// captureStderr ejecuta fn y devuelve lo que escribió en la salida de errores.
+func captureStderr(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr := os.Stderr
+	os.Stderr = w
+	defer func() { os.Stderr = stderr }()
+	fn()
+	w.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestRedirectedErrorsNotShown(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		source string
+		status int
+		shown  bool
+	}{
+		{`ls /nxsh-no-existe 2> "` + dir + `/a"`, 2, false},
+		{`get .x 2> "` + dir + `/b"`, 1, false},
+		{`nxsh-no-existe 2>> "` + dir + `/c"`, 127, false},
+		{`get .x 2> "` + dir + `/d" || echo otro`, 0, false},
+		{`get .x`, 1, true},
+		{`ls /nxsh-no-existe 2>&1 > "` + dir + `/e"`, 2, true},
+	}
+	for _, tt := range tests {
+		var status int
+		output := captureStderr(t, func() {
+			status = NewScript("test", tt.source).runScript()
+		})
+		if status != tt.status {
+			t.Errorf("%q: código de salida %d, se esperaba %d", tt.source, status, tt.status)
+		}
+		if shown := output != ""; shown != tt.shown {
+			t.Errorf("%q: salida de errores %q", tt.source, output)
+		}
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+		vars   []string
+	}{
+		{"let a = 1\nlet b = \nalias ll = ls -l\ndef f { 1 }", "rc: Error de parsing: línea 2: se esperaba una expresión, pero se obtuvo fin de línea\n", []string{"a", "ll", "f"}},
+		{"let b = ", "rc: Error de parsing: línea 1: se esperaba una expresión, pero se obtuvo final de la entrada\n", nil},
+		{"throw fallo", "rc: línea 1: Error: fallo\n", nil},
+	}
+	for _, tt := range tests {
+		s := NewScript("", "")
+		output := captureStderr(t, func() {
+			s.evalSource(context.Background(), "rc", tt.source, true)
+		})
+		if output != tt.want {
+			t.Errorf("%q: salida de errores %q, se esperaba %q", tt.source, output, tt.want)
+		}
+		for _, name := range tt.vars {
+			if _, ok := s.environment.Get(name); !ok {
+				t.Errorf("%q: no se definió %s", tt.source, name)
+			}
+		}
+	}
+}
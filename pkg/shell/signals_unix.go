@@ -0,0 +1,17 @@
+//go:build !windows
+
+package shell
+
+import (
+	"os"
+	"syscall"
+)
+
+// Señales que nxsh atiende mientras evalúa. Ctrl-C (SIGINT) y Ctrl-\ (SIGQUIT)
+// cancelan la evaluación en curso. Ctrl-Z (SIGTSTP) se descarta: nxsh no puede
+// suspenderse a sí misma, y los pipelines en primer plano ya la reciben de la
+// terminal.
+var (
+	cancelSignals  = []os.Signal{os.Interrupt, syscall.SIGQUIT}
+	ignoredSignals = []os.Signal{syscall.SIGTSTP}
+)
@@ -0,0 +1,11 @@
+//go:build windows
+
+package shell
+
+import "os"
+
+// Señales que nxsh atiende mientras evalúa: Ctrl-C cancela la evaluación en curso.
+var (
+	cancelSignals  = []os.Signal{os.Interrupt}
+	ignoredSignals []os.Signal
+)